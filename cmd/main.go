@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	"github.com/emagen-ai/cagen-quota/internal/config"
 	"github.com/emagen-ai/cagen-quota/internal/database"
 	"github.com/emagen-ai/cagen-quota/internal/handlers"
+	"github.com/emagen-ai/cagen-quota/internal/middleware"
 	"github.com/emagen-ai/cagen-quota/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -46,8 +48,56 @@ func main() {
 		logger.WithError(err).Fatal("Failed to setup auth client")
 	}
 
+	// Share replay protection across replicas when configured
+	switch cfg.NonceStore {
+	case "postgres":
+		authClient.SetNonceStore(auth.NewPostgresNonceStore(db.DB, logger))
+		logger.Info("Using Postgres nonce store")
+	case "memory":
+		logger.Info("Using in-memory nonce store")
+	default:
+		logger.Fatalf("Unknown NONCE_STORE %q (expected memory or postgres)", cfg.NonceStore)
+	}
+
+	// Accept bearer tokens when JWT verification keys are configured
+	jwtVerifier, err := setupJWTVerifier(cfg, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to setup JWT verification")
+	}
+
 	// Initialize services
-	quotaService := services.NewQuotaService(db, authClient, logger)
+	// Select the permission engine
+	var authorizer auth.Authorizer
+	switch cfg.Authorizer {
+	case "remote":
+		authorizer = authClient
+	case "local":
+		authorizer = auth.NewLocalAuthorizer(db.DB, logger)
+		logger.Info("Using local permission engine")
+	default:
+		logger.Fatalf("Unknown AUTHORIZER %q (expected remote or local)", cfg.Authorizer)
+	}
+
+	quotaService := services.NewQuotaService(db, authorizer, logger)
+	if err := quotaService.SetAuthorizationMode(cfg.AuthzMode); err != nil {
+		logger.WithError(err).Fatal("Failed to configure authorization")
+	}
+	if cfg.AuthzMode != services.AuthzModeEnforce {
+		logger.Warnf("Authorization is not enforced (AUTHZ_MODE=%s)", cfg.AuthzMode)
+	}
+	err = quotaService.SetReservationTTL(
+		time.Duration(cfg.ReservationTTLSeconds)*time.Second,
+		time.Duration(cfg.ReservationMaxTTLSeconds)*time.Second)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to configure reservations")
+	}
+	if err := quotaService.SetIdempotencyKeyTTL(time.Duration(cfg.IdempotencyKeyTTLSeconds) * time.Second); err != nil {
+		logger.WithError(err).Fatal("Failed to configure idempotency keys")
+	}
+
+	// Release expired reservations and purge old idempotency keys in the background
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	go quotaService.RunReservationReaper(reaperCtx, time.Duration(cfg.ReservationReaperSeconds)*time.Second)
 
 	// Initialize handlers
 	quotaHandler := handlers.NewQuotaHandler(quotaService, authClient, logger)
@@ -58,7 +108,7 @@ func main() {
 	}
 
 	// Initialize router
-	router := setupRouter(quotaHandler, logger, cfg)
+	router := setupRouter(quotaHandler, middleware.Authenticate(authClient, jwtVerifier, logger), logger, cfg)
 
 	// Create HTTP server
 	server := &http.Server{
@@ -82,6 +132,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down quota service...")
+	stopReaper()
 
 	// Give outstanding requests 30 seconds to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -117,44 +168,113 @@ func setupLogger(logLevel, logFormat string) *logrus.Logger {
 }
 
 func setupAuthClient(cfg *config.Config, logger *logrus.Logger) (*auth.AuthClient, error) {
-	// Get or generate shared key
-	var sharedKey []byte
-	var err error
+	// Load shared keys
+	keyring, err := loadKeyring(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create auth client
+	authClient := auth.NewAuthClient(cfg.QuotaServiceID, cfg.AuthServiceURL, keyring, logger)
+	authClient.SetMaxClockSkew(time.Duration(cfg.AuthMaxClockSkewSeconds) * time.Second)
+	authClient.SetPermissionCacheSize(cfg.PermissionCacheSize)
+	authClient.SetKeyEnvelope(cfg.AuthKeyEnvelope)
+
+	// Configure service key if needed (development mode)
+	if cfg.Environment == "development" {
+		logger.Info("Configuring service key with auth service...")
+		if err := authClient.ConfigureServiceKey(); err != nil {
+			logger.WithError(err).Warn("Failed to configure service key with auth service")
+		}
+	}
+
+	return authClient, nil
+}
+
+// setupJWTVerifier creates the bearer token verifier, or returns nil when no
+// JWT key material is configured
+func setupJWTVerifier(cfg *config.Config, logger *logrus.Logger) (*auth.JWTVerifier, error) {
+	if cfg.JWTHMACSecret == "" && cfg.JWTPublicKeyFile == "" && cfg.JWTJWKSFile == "" {
+		logger.Info("Bearer token authentication disabled")
+		return nil, nil
+	}
+
+	verifier, err := auth.NewJWTVerifier(auth.JWTConfig{
+		HMACSecret:    cfg.JWTHMACSecret,
+		PublicKeyFile: cfg.JWTPublicKeyFile,
+		JWKSFile:      cfg.JWTJWKSFile,
+		Issuer:        cfg.JWTIssuer,
+		Audience:      cfg.JWTAudience,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Bearer token authentication enabled")
+	return verifier, nil
+}
+
+// loadKeyring builds the shared keyring from CAGEN_QUOTA_SERVICE_SECRET_KEYS
+// (key_id:base64_key,...) and the single-key CAGEN_QUOTA_SERVICE_SECRET_KEY
+func loadKeyring(cfg *config.Config, logger *logrus.Logger) (*auth.Keyring, error) {
+	keyring := auth.NewKeyring()
+
+	for _, entry := range strings.Split(cfg.QuotaServiceSecretKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid service secret key entry, expected key_id:base64_key")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid service secret key format for %s: %w", parts[0], err)
+		}
+		if err := keyring.Add(parts[0], key); err != nil {
+			return nil, err
+		}
+	}
 
 	if cfg.QuotaServiceSecretKey != "" {
 		// Decode existing key
-		sharedKey, err = base64.StdEncoding.DecodeString(cfg.QuotaServiceSecretKey)
+		key, err := base64.StdEncoding.DecodeString(cfg.QuotaServiceSecretKey)
 		if err != nil {
 			return nil, fmt.Errorf("invalid service secret key format: %w", err)
 		}
-		if len(sharedKey) != 32 {
-			return nil, fmt.Errorf("service secret key must be 32 bytes, got %d", len(sharedKey))
+		if _, exists := keyring.Get(auth.KeyID(key)); !exists {
+			if err := keyring.Add(auth.KeyID(key), key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if keyring.Len() == 0 {
+		if cfg.Environment != "development" {
+			return nil, fmt.Errorf("CAGEN_QUOTA_SERVICE_SECRET_KEY is required")
 		}
-		logger.Info("Using configured service secret key")
-	} else if cfg.Environment == "development" {
 		// Generate a temporary key for development
-		sharedKey = make([]byte, 32)
-		copy(sharedKey, []byte("dev-key-for-testing-only-32bytes"))
+		key := make([]byte, auth.SharedKeySize)
+		copy(key, []byte("dev-key-for-testing-only-32bytes"))
+		if err := keyring.Add("dev", key); err != nil {
+			return nil, err
+		}
 		logger.Warn("Using development key - not suitable for production")
-	} else {
-		return nil, fmt.Errorf("CAGEN_QUOTA_SERVICE_SECRET_KEY is required")
+		return keyring, nil
 	}
 
-	// Create auth client
-	authClient := auth.NewAuthClient(cfg.QuotaServiceID, cfg.AuthServiceURL, sharedKey, logger)
-
-	// Configure service key if needed (development mode)
-	if cfg.Environment == "development" {
-		logger.Info("Configuring service key with auth service...")
-		if err := authClient.ConfigureServiceKey(); err != nil {
-			logger.WithError(err).Warn("Failed to configure service key with auth service")
+	if cfg.QuotaServicePrimaryKeyID != "" {
+		if err := keyring.SetPrimary(cfg.QuotaServicePrimaryKeyID); err != nil {
+			return nil, fmt.Errorf("invalid primary key ID: %w", err)
 		}
 	}
 
-	return authClient, nil
+	logger.WithField("key_ids", keyring.IDs()).Info("Using configured service secret keys")
+	return keyring, nil
 }
 
-func setupRouter(quotaHandler *handlers.QuotaHandler, logger *logrus.Logger, cfg *config.Config) *gin.Engine {
+func setupRouter(quotaHandler *handlers.QuotaHandler, authenticate gin.HandlerFunc, logger *logrus.Logger, cfg *config.Config) *gin.Engine {
 	router := gin.New()
 
 	// Add middleware
@@ -176,7 +296,7 @@ func setupRouter(quotaHandler *handlers.QuotaHandler, logger *logrus.Logger, cfg
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		c.Header("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Request-ID")
+		c.Header("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Request-ID, Idempotency-Key")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -191,20 +311,50 @@ func setupRouter(quotaHandler *handlers.QuotaHandler, logger *logrus.Logger, cfg
 	
 	// Quota API (v1)
 	v1 := router.Group("/api/v1")
+	v1.Use(authenticate)
 	{
 		// Core quota operations
 		v1.POST("/quotas/create", quotaHandler.CreateQuota)
 		v1.POST("/quotas/:id/allocate", quotaHandler.AllocateQuota)
 		v1.POST("/quotas/:id/release", quotaHandler.ReleaseQuota)
+		v1.POST("/quotas/:id/resize", quotaHandler.ResizeQuota)
+		v1.POST("/quotas/:id/move", quotaHandler.MoveQuota)
+		v1.POST("/quotas/:id/suspend", quotaHandler.SuspendQuota)
+		v1.POST("/quotas/:id/resume", quotaHandler.ResumeQuota)
 		v1.GET("/quotas/:id", quotaHandler.GetQuota)
+		v1.GET("/quotas/:id/tree", quotaHandler.GetQuotaTree)
+		v1.GET("/quotas/:id/ancestors", quotaHandler.GetQuotaAncestors)
+		v1.POST("/quotas/:id/rollups/check", quotaHandler.CheckRollups)
+		v1.GET("/quotas/:id/limits", quotaHandler.GetQuotaLimits)
+		v1.PUT("/quotas/:id/limits", quotaHandler.UpdateQuotaLimits)
 		v1.GET("/quotas", quotaHandler.ListQuotas)
 		
 		// Permission management
 		v1.POST("/quotas/:id/permissions/grant", quotaHandler.GrantPermission)
+		v1.POST("/quotas/:id/permissions/revoke", quotaHandler.RevokePermission)
+		v1.GET("/quotas/:id/permissions", quotaHandler.ListPermissions)
 		
 		// Usage management
 		v1.POST("/quotas/:id/usage/allocate", quotaHandler.AllocateUsage)
 		v1.POST("/quotas/:id/usage/deallocate", quotaHandler.DeallocateUsage)
+		v1.PUT("/quotas/:id/usage/:resource_id", quotaHandler.SetResourceUsage)
+		v1.POST("/usage/batch", quotaHandler.ApplyUsageBatch)
+		v1.POST("/usage/transfer", quotaHandler.TransferUsage)
+		v1.POST("/quotas/:id/reservations", quotaHandler.CreateReservation)
+		v1.POST("/quotas/:id/reservations/:reservation_id/commit", quotaHandler.CommitReservation)
+		v1.POST("/quotas/:id/reservations/:reservation_id/cancel", quotaHandler.CancelReservation)
+	}
+
+	// Operator endpoints (only when an admin token is configured)
+	if cfg.AdminAPIToken != "" {
+		admin := router.Group("/admin")
+		admin.Use(middleware.AdminAuth(cfg.AdminAPIToken, logger))
+		{
+			admin.GET("/keys", quotaHandler.ListServiceKeys)
+			admin.POST("/keys/rotate", quotaHandler.RotateServiceKey)
+			admin.DELETE("/keys/:key_id", quotaHandler.RetireServiceKey)
+			admin.GET("/permission-cache", quotaHandler.PermissionCacheStats)
+		}
 	}
 
 	// Development endpoints (only in development mode)
@@ -273,7 +423,7 @@ func ginLogger(logger *logrus.Logger) gin.HandlerFunc {
 			}
 
 			if raw != "" {
-				param.Path = path + "?" + raw
+				param.Path = path + "?" + middleware.RedactQuery(raw)
 			} else {
 				param.Path = path
 			}
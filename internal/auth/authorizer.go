@@ -0,0 +1,35 @@
+package auth
+
+// Authorizer decides and manages permissions on quota resources
+type Authorizer interface {
+	// CheckPermission reports whether the user holds all permissions on the resource
+	CheckPermission(userInfo *UserInfo, resourceID string, permissions []string) (bool, error)
+
+	// ResolvePermissions returns the permissions granted and explicitly denied
+	// on exactly this resource; inheritance is resolved by the caller
+	ResolvePermissions(userInfo *UserInfo, resourceID string, permissions []string) (*PermissionResult, error)
+
+	// GrantPermission grants permissions on the resource to the target user,
+	// optionally until expiresAt (Unix milliseconds)
+	GrantPermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string, expiresAt *int64) error
+
+	// DenyPermission explicitly denies permissions on the resource to the target user
+	DenyPermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string, expiresAt *int64) error
+
+	// RevokePermission removes the target user's grants or denies on the resource
+	RevokePermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string) error
+
+	// ListPermissions lists the grants and denies held on the resource
+	ListPermissions(userInfo *UserInfo, resourceID string) ([]PermissionEntry, error)
+
+	// CreateResource registers a new resource owned by the user
+	CreateResource(userInfo *UserInfo, resourceID, resourceType, displayName, description string) error
+
+	// DeleteResource removes a resource and every grant held on it
+	DeleteResource(userInfo *UserInfo, resourceID string) error
+
+	// InvalidatePermissions drops any cached decisions for the resource
+	InvalidatePermissions(resourceID string)
+}
+
+var _ Authorizer = (*AuthClient)(nil)
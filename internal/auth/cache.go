@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPermissionCacheSize is the default number of cached permission decisions
+const DefaultPermissionCacheSize = 10000
+
+// PermissionCacheStats reports permission cache effectiveness
+type PermissionCacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+}
+
+// permissionCache is a bounded LRU of permission decisions keyed on
+// (user, resource, permission set). Entries expire after the CacheTTL
+// returned by the auth service.
+type permissionCache struct {
+	mu         sync.Mutex
+	capacity   int
+	order      *list.List
+	entries    map[string]*list.Element
+	byResource map[string]map[string]struct{}
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type permissionCacheEntry struct {
+	key        string
+	resourceID string
+	result     *PermissionResult
+	expiresAt  time.Time
+}
+
+func newPermissionCache(capacity int) *permissionCache {
+	return &permissionCache{
+		capacity:   capacity,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		byResource: make(map[string]map[string]struct{}),
+	}
+}
+
+// permissionCacheKey builds an order-independent cache key
+func permissionCacheKey(userID, resourceID string, permissions []string) string {
+	sorted := append([]string(nil), permissions...)
+	sort.Strings(sorted)
+	return userID + "|" + resourceID + "|" + strings.Join(sorted, ",")
+}
+
+func (pc *permissionCache) get(key string) (*PermissionResult, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	elem, exists := pc.entries[key]
+	if !exists {
+		pc.misses.Add(1)
+		return nil, false
+	}
+
+	entry := elem.Value.(*permissionCacheEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		pc.removeElement(elem)
+		pc.misses.Add(1)
+		return nil, false
+	}
+
+	pc.order.MoveToFront(elem)
+	pc.hits.Add(1)
+	return entry.result, true
+}
+
+func (pc *permissionCache) set(key, resourceID string, result *PermissionResult, ttl time.Duration) {
+	if pc.capacity <= 0 || ttl <= 0 {
+		return
+	}
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if elem, exists := pc.entries[key]; exists {
+		pc.removeElement(elem)
+	}
+
+	entry := &permissionCacheEntry{
+		key:        key,
+		resourceID: resourceID,
+		result:     result,
+		expiresAt:  time.Now().Add(ttl),
+	}
+	pc.entries[key] = pc.order.PushFront(entry)
+	if pc.byResource[resourceID] == nil {
+		pc.byResource[resourceID] = make(map[string]struct{})
+	}
+	pc.byResource[resourceID][key] = struct{}{}
+
+	// Evict least recently used entries
+	for pc.order.Len() > pc.capacity {
+		pc.removeElement(pc.order.Back())
+	}
+}
+
+// invalidateResource drops every cached decision for a resource
+func (pc *permissionCache) invalidateResource(resourceID string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	for key := range pc.byResource[resourceID] {
+		if elem, exists := pc.entries[key]; exists {
+			pc.removeElement(elem)
+		}
+	}
+	delete(pc.byResource, resourceID)
+}
+
+func (pc *permissionCache) removeElement(elem *list.Element) {
+	entry := pc.order.Remove(elem).(*permissionCacheEntry)
+	delete(pc.entries, entry.key)
+	if keys, exists := pc.byResource[entry.resourceID]; exists {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(pc.byResource, entry.resourceID)
+		}
+	}
+}
+
+func (pc *permissionCache) stats() PermissionCacheStats {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	return PermissionCacheStats{
+		Hits:     pc.hits.Load(),
+		Misses:   pc.misses.Load(),
+		Size:     pc.order.Len(),
+		Capacity: pc.capacity,
+	}
+}
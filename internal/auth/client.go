@@ -7,22 +7,39 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+// Errors returned when an encrypted user payload cannot be accepted
+var (
+	ErrInvalidPayload  = errors.New("invalid encrypted payload")
+	ErrPayloadExpired  = errors.New("encrypted payload expired")
+	ErrPayloadReplayed = errors.New("encrypted payload replayed")
+)
+
+// DefaultMaxClockSkew is the default accepted age of an encrypted payload
+const DefaultMaxClockSkew = 5 * time.Minute
+
 // AuthClient handles communication with the auth service
 type AuthClient struct {
-	serviceID   string
-	sharedKey   []byte
-	authBaseURL string
-	httpClient  *http.Client
-	logger      *logrus.Logger
+	serviceID    string
+	keyring      *Keyring
+	authBaseURL  string
+	httpClient   *http.Client
+	logger       *logrus.Logger
+	maxClockSkew time.Duration
+	nonceStore   NonceStore
+	keyEnvelope  bool
+
+	permissionCache *permissionCache
 }
 
 // UserInfo represents user information to be encrypted
@@ -58,6 +75,39 @@ type PermissionResult struct {
 	DeniedPermissions  []string `json:"denied_permissions"`
 	ResourceExists     bool     `json:"resource_exists"`
 	CacheTTL           int      `json:"cache_ttl"`
+
+	// ExplicitlyDeniedPermissions are denied by an explicit deny entry on the
+	// resource, as opposed to simply not being granted
+	ExplicitlyDeniedPermissions []string `json:"explicitly_denied_permissions,omitempty"`
+}
+
+// HasAll reports whether every permission is granted
+func (pr *PermissionResult) HasAll(permissions []string) bool {
+	for _, perm := range permissions {
+		if !pr.Grants(perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// Grants reports whether the permission is granted and not explicitly denied
+func (pr *PermissionResult) Grants(permission string) bool {
+	return containsString(pr.GrantedPermissions, permission) && !pr.Denies(permission)
+}
+
+// Denies reports whether the permission is explicitly denied
+func (pr *PermissionResult) Denies(permission string) bool {
+	return containsString(pr.ExplicitlyDeniedPermissions, permission)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // PermissionGrantRequest represents a permission grant request
@@ -68,6 +118,42 @@ type PermissionGrantRequest struct {
 	ResourceID    string   `json:"resource_id"`
 	Permissions   []string `json:"permissions"`
 	ExpiresAt     *int64   `json:"expires_at,omitempty"`
+	Effect        string   `json:"effect,omitempty"`
+}
+
+// PermissionRevokeRequest represents a permission revoke request
+type PermissionRevokeRequest struct {
+	ServiceID     string   `json:"service_id"`
+	EncryptedData string   `json:"encrypted_data"`
+	TargetUserID  string   `json:"target_user_id"`
+	ResourceID    string   `json:"resource_id"`
+	Permissions   []string `json:"permissions"`
+}
+
+// PermissionListRequest represents a request to list grants on a resource
+type PermissionListRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
+	ResourceID    string `json:"resource_id"`
+}
+
+// PermissionListResponse represents the response from permission list
+type PermissionListResponse struct {
+	Success bool `json:"success"`
+	Data    *struct {
+		Permissions []PermissionEntry `json:"permissions"`
+	} `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// PermissionEntry is a single grant (or explicit deny) held by a user on a resource
+type PermissionEntry struct {
+	UserID     string `json:"user_id"`
+	ResourceID string `json:"resource_id"`
+	Permission string `json:"permission"`
+	Effect     string `json:"effect"`
+	GrantedBy  string `json:"granted_by,omitempty"`
+	ExpiresAt  *int64 `json:"expires_at,omitempty"` // Unix milliseconds
 }
 
 // ResourceCreateRequest represents a resource creation request
@@ -81,16 +167,27 @@ type ResourceCreateRequest struct {
 	Metadata      string `json:"metadata"`
 }
 
+// ResourceDeleteRequest represents a resource deletion request
+type ResourceDeleteRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
+	ResourceID    string `json:"resource_id"`
+}
+
 // NewAuthClient creates a new auth service client
-func NewAuthClient(serviceID, authBaseURL string, sharedKey []byte, logger *logrus.Logger) *AuthClient {
+func NewAuthClient(serviceID, authBaseURL string, keyring *Keyring, logger *logrus.Logger) *AuthClient {
 	return &AuthClient{
 		serviceID:   serviceID,
-		sharedKey:   sharedKey,
+		keyring:     keyring,
 		authBaseURL: authBaseURL,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		logger: logger,
+		logger:       logger,
+		maxClockSkew: DefaultMaxClockSkew,
+		nonceStore:   NewMemoryNonceStore(),
+
+		permissionCache: newPermissionCache(DefaultPermissionCacheSize),
 	}
 }
 
@@ -99,24 +196,67 @@ func (ac *AuthClient) ServiceID() string {
 	return ac.serviceID
 }
 
-// EncryptUserInfo encrypts user information for sending to auth service
-func (ac *AuthClient) EncryptUserInfo(userInfo *UserInfo) (string, error) {
-	// Set timestamp and nonce if not already set
-	if userInfo.Timestamp == 0 {
-		userInfo.Timestamp = time.Now().UnixMilli()
-	}
-	if userInfo.Nonce == "" {
-		userInfo.Nonce = uuid.New().String()
+// SetMaxClockSkew sets how far a payload timestamp may drift from the local clock
+func (ac *AuthClient) SetMaxClockSkew(skew time.Duration) {
+	if skew > 0 {
+		ac.maxClockSkew = skew
 	}
+}
+
+// SetKeyEnvelope selects whether outbound payloads are tagged with the primary
+// key ID (key_id.base64). Leave disabled until the auth service accepts the
+// envelope; untagged payloads are the legacy wire format.
+func (ac *AuthClient) SetKeyEnvelope(enabled bool) {
+	ac.keyEnvelope = enabled
+}
+
+// SetNonceStore replaces the store used for replay detection
+func (ac *AuthClient) SetNonceStore(store NonceStore) {
+	ac.nonceStore = store
+}
+
+// SetPermissionCacheSize resizes the permission decision cache (0 disables it)
+func (ac *AuthClient) SetPermissionCacheSize(size int) {
+	ac.permissionCache = newPermissionCache(size)
+}
+
+// PermissionCacheStats returns permission cache hit/miss counters
+func (ac *AuthClient) PermissionCacheStats() PermissionCacheStats {
+	return ac.permissionCache.stats()
+}
+
+// InvalidatePermissions drops cached permission decisions for a resource
+func (ac *AuthClient) InvalidatePermissions(resourceID string) {
+	ac.permissionCache.invalidateResource(resourceID)
+}
+
+// Keyring returns the shared keys used by the client
+func (ac *AuthClient) Keyring() *Keyring {
+	return ac.keyring
+}
+
+// EncryptUserInfo encrypts user information for sending to auth service.
+// Every payload gets a fresh timestamp and nonce; the caller's values (which
+// usually came from an inbound payload) are never reused.
+func (ac *AuthClient) EncryptUserInfo(userInfo *UserInfo) (string, error) {
+	outbound := *userInfo
+	outbound.Timestamp = time.Now().UnixMilli()
+	outbound.Nonce = uuid.New().String()
 
 	// Serialize to JSON
-	plaintext, err := json.Marshal(userInfo)
+	plaintext, err := json.Marshal(&outbound)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal user info: %w", err)
 	}
 
+	// Encrypt with the primary key
+	keyID, key, err := ac.keyring.Primary()
+	if err != nil {
+		return "", err
+	}
+
 	// Create cipher
-	block, err := aes.NewCipher(ac.sharedKey)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
 	}
@@ -138,16 +278,119 @@ func (ac *AuthClient) EncryptUserInfo(userInfo *UserInfo) (string, error) {
 	// Combine nonce and ciphertext
 	encrypted := append(nonce, ciphertext...)
 
-	// Encode to base64
-	return base64.StdEncoding.EncodeToString(encrypted), nil
+	// Encode to base64, tagging with the key ID only when the peer expects it
+	encoded := base64.StdEncoding.EncodeToString(encrypted)
+	if !ac.keyEnvelope {
+		return encoded, nil
+	}
+	return keyID + envelopeSeparator + encoded, nil
+}
+
+// DecryptUserInfo decrypts user information produced by EncryptUserInfo and
+// rejects payloads that are stale or whose nonce has already been seen
+func (ac *AuthClient) DecryptUserInfo(encryptedData string) (*UserInfo, error) {
+	// Split the optional key ID from the ciphertext
+	keyID, payload := "", encryptedData
+	if idx := strings.Index(encryptedData, envelopeSeparator); idx >= 0 {
+		keyID, payload = encryptedData[:idx], encryptedData[idx+1:]
+	}
+
+	// Decode from base64
+	encrypted, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64 encoding", ErrInvalidPayload)
+	}
+
+	// Tagged payloads use their key; untagged (legacy) payloads may use any key
+	keyIDs := ac.keyring.IDs()
+	if keyID != "" {
+		keyIDs = []string{keyID}
+	}
+
+	var plaintext []byte
+	for _, id := range keyIDs {
+		key, exists := ac.keyring.Get(id)
+		if !exists {
+			continue
+		}
+		if plaintext, err = openPayload(key, encrypted); err == nil {
+			break
+		}
+	}
+	if plaintext == nil {
+		return nil, fmt.Errorf("%w: authentication failed", ErrInvalidPayload)
+	}
+
+	var userInfo UserInfo
+	if err := json.Unmarshal(plaintext, &userInfo); err != nil {
+		return nil, fmt.Errorf("%w: malformed user info", ErrInvalidPayload)
+	}
+	if userInfo.UserID == "" || userInfo.Nonce == "" || userInfo.Timestamp == 0 {
+		return nil, fmt.Errorf("%w: missing user_id, nonce or timestamp", ErrInvalidPayload)
+	}
+
+	// Reject stale or future-dated payloads
+	issuedAt := time.UnixMilli(userInfo.Timestamp)
+	now := time.Now()
+	if now.Sub(issuedAt) > ac.maxClockSkew || issuedAt.Sub(now) > ac.maxClockSkew {
+		return nil, fmt.Errorf("%w: timestamp outside accepted window of %s", ErrPayloadExpired, ac.maxClockSkew)
+	}
+
+	// Reject nonces already seen within the window
+	fresh, err := ac.nonceStore.Remember(userInfo.Nonce, issuedAt.Add(ac.maxClockSkew))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check nonce: %w", err)
+	}
+	if !fresh {
+		return nil, ErrPayloadReplayed
+	}
+
+	return &userInfo, nil
+}
+
+// openPayload decrypts a nonce-prefixed AES-GCM payload with the given key
+func openPayload(key, encrypted []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	if len(encrypted) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("payload too short")
+	}
+	nonce, ciphertext := encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, nil)
 }
 
 // CheckPermission checks if a user has specific permissions on a resource
 func (ac *AuthClient) CheckPermission(userInfo *UserInfo, resourceID string, permissions []string) (bool, error) {
+	result, err := ac.ResolvePermissions(userInfo, resourceID, permissions)
+	if err != nil {
+		return false, err
+	}
+
+	return result.HasAll(permissions), nil
+}
+
+// ResolvePermissions returns the permissions granted to, and explicitly denied
+// for, a user on a resource
+func (ac *AuthClient) ResolvePermissions(userInfo *UserInfo, resourceID string, permissions []string) (*PermissionResult, error) {
+	// Serve from cache while the auth service's TTL holds
+	cacheKey := permissionCacheKey(userInfo.UserID, resourceID, permissions)
+	if result, found := ac.permissionCache.get(cacheKey); found {
+		return result, nil
+	}
+
 	// Encrypt user info
 	encryptedData, err := ac.EncryptUserInfo(userInfo)
 	if err != nil {
-		return false, fmt.Errorf("failed to encrypt user info: %w", err)
+		return nil, fmt.Errorf("failed to encrypt user info: %w", err)
 	}
 
 	// Prepare request
@@ -162,40 +405,38 @@ func (ac *AuthClient) CheckPermission(userInfo *UserInfo, resourceID string, per
 	var response PermissionCheckResponse
 	err = ac.sendRequest("POST", "/api/v1/permission/check", request, &response)
 	if err != nil {
-		return false, fmt.Errorf("permission check request failed: %w", err)
+		return nil, fmt.Errorf("permission check request failed: %w", err)
 	}
 
-	if !response.Success {
+	if !response.Success || response.Data == nil {
 		ac.logger.WithFields(logrus.Fields{
 			"user_id":     userInfo.UserID,
 			"resource_id": resourceID,
 			"permissions": permissions,
 			"error":       response.Error,
 		}).Debug("Permission check failed")
-		return false, nil
+		return &PermissionResult{UserID: userInfo.UserID, ResourceID: resourceID}, nil
 	}
 
-	// Check if all requested permissions are granted
-	if response.Data == nil {
-		return false, nil
-	}
+	ac.permissionCache.set(cacheKey, resourceID, response.Data, time.Duration(response.Data.CacheTTL)*time.Second)
 
-	grantedSet := make(map[string]bool)
-	for _, perm := range response.Data.GrantedPermissions {
-		grantedSet[perm] = true
-	}
+	return response.Data, nil
+}
 
-	for _, requested := range permissions {
-		if !grantedSet[requested] {
-			return false, nil
-		}
-	}
+// GrantPermission grants permissions to a user, optionally until expiresAt
+// (Unix milliseconds)
+func (ac *AuthClient) GrantPermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string, expiresAt *int64) error {
+	return ac.sendGrant(adminUserInfo, targetUserID, resourceID, permissions, PermissionEffectAllow, expiresAt)
+}
 
-	return true, nil
+// DenyPermission explicitly denies permissions to a user, overriding grants
+// inherited from ancestor resources
+func (ac *AuthClient) DenyPermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string, expiresAt *int64) error {
+	return ac.sendGrant(adminUserInfo, targetUserID, resourceID, permissions, PermissionEffectDeny, expiresAt)
 }
 
-// GrantPermission grants permissions to a user
-func (ac *AuthClient) GrantPermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string) error {
+// sendGrant sends a permission grant request with the given effect
+func (ac *AuthClient) sendGrant(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string, effect string, expiresAt *int64) error {
 	// Encrypt admin user info
 	encryptedData, err := ac.EncryptUserInfo(adminUserInfo)
 	if err != nil {
@@ -209,6 +450,10 @@ func (ac *AuthClient) GrantPermission(adminUserInfo *UserInfo, targetUserID, res
 		TargetUserID:  targetUserID,
 		ResourceID:    resourceID,
 		Permissions:   permissions,
+		ExpiresAt:     expiresAt,
+	}
+	if effect != PermissionEffectAllow {
+		request.Effect = effect
 	}
 
 	// Send request
@@ -226,9 +471,80 @@ func (ac *AuthClient) GrantPermission(adminUserInfo *UserInfo, targetUserID, res
 		return fmt.Errorf("permission grant failed: %s", errorMsg)
 	}
 
+	ac.InvalidatePermissions(resourceID)
+
 	return nil
 }
 
+// RevokePermission removes permissions (grants or denies) from a user
+func (ac *AuthClient) RevokePermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string) error {
+	// Encrypt admin user info
+	encryptedData, err := ac.EncryptUserInfo(adminUserInfo)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt admin user info: %w", err)
+	}
+
+	// Prepare request
+	request := PermissionRevokeRequest{
+		ServiceID:     ac.serviceID,
+		EncryptedData: encryptedData,
+		TargetUserID:  targetUserID,
+		ResourceID:    resourceID,
+		Permissions:   permissions,
+	}
+
+	// Send request
+	var response map[string]interface{}
+	err = ac.sendRequest("POST", "/api/v1/permission/revoke", request, &response)
+	if err != nil {
+		return fmt.Errorf("permission revoke request failed: %w", err)
+	}
+
+	if success, ok := response["success"]; !ok || !success.(bool) {
+		errorMsg := "unknown error"
+		if msg, exists := response["error"]; exists {
+			errorMsg = msg.(string)
+		}
+		return fmt.Errorf("permission revoke failed: %s", errorMsg)
+	}
+
+	ac.InvalidatePermissions(resourceID)
+
+	return nil
+}
+
+// ListPermissions lists the grants held on a resource
+func (ac *AuthClient) ListPermissions(userInfo *UserInfo, resourceID string) ([]PermissionEntry, error) {
+	// Encrypt user info
+	encryptedData, err := ac.EncryptUserInfo(userInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt user info: %w", err)
+	}
+
+	// Prepare request
+	request := PermissionListRequest{
+		ServiceID:     ac.serviceID,
+		EncryptedData: encryptedData,
+		ResourceID:    resourceID,
+	}
+
+	// Send request
+	var response PermissionListResponse
+	err = ac.sendRequest("POST", "/api/v1/permission/list", request, &response)
+	if err != nil {
+		return nil, fmt.Errorf("permission list request failed: %w", err)
+	}
+
+	if !response.Success {
+		return nil, fmt.Errorf("permission list failed: %s", response.Error)
+	}
+
+	if response.Data == nil {
+		return []PermissionEntry{}, nil
+	}
+	return response.Data.Permissions, nil
+}
+
 // CreateResource creates a new resource in the auth service
 func (ac *AuthClient) CreateResource(userInfo *UserInfo, resourceID, resourceType, displayName, description string) error {
 	// Encrypt user info
@@ -266,6 +582,41 @@ func (ac *AuthClient) CreateResource(userInfo *UserInfo, resourceID, resourceTyp
 	return nil
 }
 
+// DeleteResource deletes a resource, and the grants held on it, from the auth service
+func (ac *AuthClient) DeleteResource(userInfo *UserInfo, resourceID string) error {
+	// Encrypt user info
+	encryptedData, err := ac.EncryptUserInfo(userInfo)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt user info: %w", err)
+	}
+
+	// Prepare request
+	request := ResourceDeleteRequest{
+		ServiceID:     ac.serviceID,
+		EncryptedData: encryptedData,
+		ResourceID:    resourceID,
+	}
+
+	// Send request
+	var response map[string]interface{}
+	err = ac.sendRequest("POST", "/api/v1/resources/delete", request, &response)
+	if err != nil {
+		return fmt.Errorf("resource deletion request failed: %w", err)
+	}
+
+	if success, ok := response["success"]; !ok || !success.(bool) {
+		errorMsg := "unknown error"
+		if msg, exists := response["error"]; exists {
+			errorMsg = msg.(string)
+		}
+		return fmt.Errorf("resource deletion failed: %s", errorMsg)
+	}
+
+	ac.InvalidatePermissions(resourceID)
+
+	return nil
+}
+
 // sendRequest sends an HTTP request to the auth service
 func (ac *AuthClient) sendRequest(method, endpoint string, body interface{}, response interface{}) error {
 	// Marshal request body
@@ -318,15 +669,60 @@ func (ac *AuthClient) sendRequest(method, endpoint string, body interface{}, res
 	return nil
 }
 
-// ConfigureServiceKey configures the service key with auth service (for initial setup)
+// ConfigureServiceKey configures the primary service key with auth service (for initial setup)
 func (ac *AuthClient) ConfigureServiceKey() error {
-	if len(ac.sharedKey) == 0 {
-		return fmt.Errorf("shared key not configured")
+	keyID, key, err := ac.keyring.Primary()
+	if err != nil {
+		return err
+	}
+
+	if err := ac.configureKey(keyID, key); err != nil {
+		return err
+	}
+
+	ac.logger.WithField("key_id", keyID).Info("Service key configured successfully")
+	return nil
+}
+
+// RotateServiceKey registers a key already loaded from configuration with the
+// auth service and makes it the primary. Keys are never generated or stored at
+// runtime, and the new primary only lasts until restart unless the configured
+// primary key ID is changed too. Previous keys stay in the ring so in-flight
+// payloads encrypted with them can still be decrypted until they are retired.
+func (ac *AuthClient) RotateServiceKey(keyID string) error {
+	key, exists := ac.keyring.Get(keyID)
+	if !exists {
+		return fmt.Errorf("key %s not found; add it to CAGEN_QUOTA_SERVICE_SECRET_KEYS first", keyID)
+	}
+
+	// Register with the auth service before using the key for anything
+	if err := ac.configureKey(keyID, key); err != nil {
+		return err
 	}
 
-	keyBase64 := base64.StdEncoding.EncodeToString(ac.sharedKey)
+	if err := ac.keyring.SetPrimary(keyID); err != nil {
+		return err
+	}
+
+	ac.logger.WithField("key_id", keyID).Info("Service key rotated successfully")
+	return nil
+}
+
+// RetireServiceKey removes a non-primary key from the ring until restart
+func (ac *AuthClient) RetireServiceKey(keyID string) error {
+	if err := ac.keyring.Remove(keyID); err != nil {
+		return err
+	}
+
+	ac.logger.WithField("key_id", keyID).Info("Service key retired")
+	return nil
+}
+
+// configureKey pushes a single key to the auth service
+func (ac *AuthClient) configureKey(keyID string, key []byte) error {
 	request := map[string]string{
-		"shared_key": keyBase64,
+		"key_id":     keyID,
+		"shared_key": base64.StdEncoding.EncodeToString(key),
 	}
 
 	var response map[string]interface{}
@@ -344,7 +740,6 @@ func (ac *AuthClient) ConfigureServiceKey() error {
 		return fmt.Errorf("service key configuration failed: %s", errorMsg)
 	}
 
-	ac.logger.Info("Service key configured successfully")
 	return nil
 }
 
@@ -353,4 +748,10 @@ const (
 	QuotaPermissionRead  = "read"
 	QuotaPermissionAdmin = "admin"
 	QuotaPermissionOwner = "owner"
+)
+
+// Permission grant effects
+const (
+	PermissionEffectAllow = "allow"
+	PermissionEffectDeny  = "deny"
 )
\ No newline at end of file
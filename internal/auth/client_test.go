@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testKey(fill byte) []byte {
+	key := make([]byte, SharedKeySize)
+	for i := range key {
+		key[i] = fill
+	}
+	return key
+}
+
+func newTestClient(t *testing.T, keys map[string][]byte, primaryID string) *AuthClient {
+	t.Helper()
+	keyring := NewKeyring()
+	for id, key := range keys {
+		if err := keyring.Add(id, key); err != nil {
+			t.Fatalf("Add(%s): %v", id, err)
+		}
+	}
+	if err := keyring.SetPrimary(primaryID); err != nil {
+		t.Fatalf("SetPrimary(%s): %v", primaryID, err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewAuthClient("svc_test", "http://auth.invalid", keyring, logger)
+}
+
+// sealUserInfo encrypts a payload exactly as given, so tests can control the
+// timestamp and nonce
+func sealUserInfo(t *testing.T, key []byte, userInfo *UserInfo) string {
+	t.Helper()
+	plaintext, err := json.Marshal(userInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil))
+}
+
+func TestDecryptUserInfo(t *testing.T) {
+	primary := testKey(1)
+	now := time.Now()
+
+	payload := func(ts time.Time, nonce string) *UserInfo {
+		return &UserInfo{UserID: "user_1", OrganizationID: "org_1", Timestamp: ts.UnixMilli(), Nonce: nonce}
+	}
+
+	tests := []struct {
+		name    string
+		data    func(t *testing.T) string
+		wantErr error
+	}{
+		{
+			name: "valid payload",
+			data: func(t *testing.T) string { return sealUserInfo(t, primary, payload(now, "n-legacy")) },
+		},
+		{
+			name:    "unknown key",
+			data:    func(t *testing.T) string { return sealUserInfo(t, testKey(9), payload(now, "n-unknown")) },
+			wantErr: ErrInvalidPayload,
+		},
+		{
+			name: "tampered ciphertext",
+			data: func(t *testing.T) string {
+				raw, _ := base64.StdEncoding.DecodeString(sealUserInfo(t, primary, payload(now, "n-tampered")))
+				raw[len(raw)-1] ^= 0xff
+				return base64.StdEncoding.EncodeToString(raw)
+			},
+			wantErr: ErrInvalidPayload,
+		},
+		{
+			name:    "not base64",
+			data:    func(t *testing.T) string { return "%%%" },
+			wantErr: ErrInvalidPayload,
+		},
+		{
+			name:    "too short",
+			data:    func(t *testing.T) string { return base64.StdEncoding.EncodeToString([]byte("short")) },
+			wantErr: ErrInvalidPayload,
+		},
+		{
+			name:    "missing nonce",
+			data:    func(t *testing.T) string { return sealUserInfo(t, primary, payload(now, "")) },
+			wantErr: ErrInvalidPayload,
+		},
+		{
+			name:    "missing timestamp",
+			data:    func(t *testing.T) string { return sealUserInfo(t, primary, payload(time.UnixMilli(0), "n-no-ts")) },
+			wantErr: ErrInvalidPayload,
+		},
+		{
+			name: "stale timestamp",
+			data: func(t *testing.T) string {
+				return sealUserInfo(t, primary, payload(now.Add(-10*time.Minute), "n-stale"))
+			},
+			wantErr: ErrPayloadExpired,
+		},
+		{
+			name: "future timestamp",
+			data: func(t *testing.T) string {
+				return sealUserInfo(t, primary, payload(now.Add(10*time.Minute), "n-future"))
+			},
+			wantErr: ErrPayloadExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, map[string][]byte{"k1": primary}, "k1")
+			userInfo, err := client.DecryptUserInfo(tt.data(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DecryptUserInfo() error = %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecryptUserInfo() error = %v", err)
+			}
+			if userInfo.UserID != "user_1" || userInfo.OrganizationID != "org_1" {
+				t.Fatalf("DecryptUserInfo() = %+v", userInfo)
+			}
+		})
+	}
+}
+
+func TestDecryptUserInfoRejectsReplay(t *testing.T) {
+	key := testKey(1)
+	client := newTestClient(t, map[string][]byte{"k1": key}, "k1")
+	data := sealUserInfo(t, key, &UserInfo{UserID: "user_1", Timestamp: time.Now().UnixMilli(), Nonce: "n-once"})
+
+	if _, err := client.DecryptUserInfo(data); err != nil {
+		t.Fatalf("first DecryptUserInfo() error = %v", err)
+	}
+	if _, err := client.DecryptUserInfo(data); !errors.Is(err, ErrPayloadReplayed) {
+		t.Fatalf("second DecryptUserInfo() error = %v; want %v", err, ErrPayloadReplayed)
+	}
+}
+
+func TestEncryptUserInfoUsesFreshTimestampAndNonce(t *testing.T) {
+	key := testKey(1)
+	sender := newTestClient(t, map[string][]byte{"k1": key}, "k1")
+	receiver := newTestClient(t, map[string][]byte{"k1": key}, "k1")
+
+	// An inbound payload that is already old and whose nonce was seen
+	inbound := &UserInfo{UserID: "user_1", Timestamp: time.Now().Add(-4 * time.Minute).UnixMilli(), Nonce: "n-inbound"}
+
+	for i := 0; i < 2; i++ {
+		data, err := sender.EncryptUserInfo(inbound)
+		if err != nil {
+			t.Fatalf("EncryptUserInfo() error = %v", err)
+		}
+		decrypted, err := receiver.DecryptUserInfo(data)
+		if err != nil {
+			t.Fatalf("outbound call %d rejected: %v", i+1, err)
+		}
+		if decrypted.Nonce == inbound.Nonce || decrypted.Timestamp == inbound.Timestamp {
+			t.Fatalf("outbound call %d reused the inbound nonce or timestamp", i+1)
+		}
+	}
+
+	if inbound.Nonce != "n-inbound" {
+		t.Fatalf("EncryptUserInfo() modified the caller's UserInfo")
+	}
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Errors returned when a bearer token cannot be accepted
+var (
+	ErrInvalidToken = errors.New("invalid bearer token")
+	ErrTokenExpired = errors.New("bearer token expired")
+)
+
+// jwtLeeway tolerates small clock differences on exp/nbf/iat
+const jwtLeeway = 30 * time.Second
+
+// JWTConfig configures bearer token verification. At least one of HMACSecret,
+// PublicKeyFile or JWKSFile must be set.
+type JWTConfig struct {
+	HMACSecret    string // HS256 shared secret
+	PublicKeyFile string // PEM-encoded RSA public key for RS256
+	JWKSFile      string // JWKS document with RSA keys for RS256
+	Issuer        string // expected iss claim, if set
+	Audience      string // expected aud claim, if set
+}
+
+// JWTVerifier verifies HS256/RS256 bearer tokens and maps their claims to UserInfo
+type JWTVerifier struct {
+	hmacKey  []byte
+	rsaKeys  map[string]*rsa.PublicKey // keyed by kid; "" for a key without one
+	issuer   string
+	audience string
+}
+
+// jwtClaims are the claims mapped onto UserInfo
+type jwtClaims struct {
+	Subject        string          `json:"sub"`
+	Issuer         string          `json:"iss"`
+	Audience       json.RawMessage `json:"aud"`
+	ExpiresAt      int64           `json:"exp"`
+	NotBefore      int64           `json:"nbf"`
+	UserID         string          `json:"user_id"`
+	SessionID      string          `json:"session_id"`
+	SID            string          `json:"sid"`
+	OrganizationID string          `json:"organization_id"`
+	OrgID          string          `json:"org_id"`
+	TeamIDs        []string        `json:"team_ids"`
+}
+
+// NewJWTVerifier creates a verifier from the configured key material
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	verifier := &JWTVerifier{
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+	}
+
+	if cfg.HMACSecret != "" {
+		verifier.hmacKey = []byte(cfg.HMACSecret)
+	}
+
+	if cfg.PublicKeyFile != "" {
+		key, err := loadRSAPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		verifier.rsaKeys[""] = key
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range keys {
+			verifier.rsaKeys[kid] = key
+		}
+	}
+
+	if verifier.hmacKey == nil && len(verifier.rsaKeys) == 0 {
+		return nil, fmt.Errorf("no JWT verification key configured")
+	}
+
+	return verifier, nil
+}
+
+// Verify checks a compact JWT and returns the user it identifies
+func (v *JWTVerifier) Verify(token string) (*UserInfo, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	signingInput := parts[0] + "." + parts[1]
+
+	switch header.Alg {
+	case "HS256":
+		if err := v.verifyHS256(signingInput, signature); err != nil {
+			return nil, err
+		}
+	case "RS256":
+		if err := v.verifyRS256(signingInput, signature, header.Kid); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	if err := v.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+
+	return claims.userInfo(), nil
+}
+
+func (v *JWTVerifier) verifyHS256(signingInput string, signature []byte) error {
+	if v.hmacKey == nil {
+		return fmt.Errorf("%w: HS256 not configured", ErrInvalidToken)
+	}
+
+	mac := hmac.New(sha256.New, v.hmacKey)
+	mac.Write([]byte(signingInput))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+	}
+	return nil
+}
+
+func (v *JWTVerifier) verifyRS256(signingInput string, signature []byte, kid string) error {
+	if len(v.rsaKeys) == 0 {
+		return fmt.Errorf("%w: RS256 not configured", ErrInvalidToken)
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+
+	// Prefer the key named by kid; otherwise try every configured key
+	if key, exists := v.rsaKeys[kid]; exists {
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+		return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+	}
+	for _, key := range v.rsaKeys {
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+}
+
+func (v *JWTVerifier) validateClaims(claims *jwtClaims) error {
+	now := time.Now()
+
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: missing exp claim", ErrInvalidToken)
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(jwtLeeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	}
+
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if v.audience != "" && !claims.hasAudience(v.audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	if claims.userInfo().UserID == "" {
+		return fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+	return nil
+}
+
+// hasAudience accepts aud as either a string or a list of strings
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(c.Audience, &list) == nil {
+		return containsString(list, audience)
+	}
+	return false
+}
+
+// userInfo maps the identity claims onto UserInfo. Timestamp and Nonce are
+// left empty: jti and iat belong to the token issuer, and outbound payloads
+// get their own fresh values when they are encrypted.
+func (c *jwtClaims) userInfo() *UserInfo {
+	return &UserInfo{
+		UserID:         firstNonEmpty(c.UserID, c.Subject),
+		SessionID:      firstNonEmpty(c.SessionID, c.SID),
+		OrganizationID: firstNonEmpty(c.OrganizationID, c.OrgID),
+		TeamIDs:        c.TeamIDs,
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// loadRSAPublicKey reads a PEM-encoded RSA public key (PKIX or PKCS#1)
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid JWT public key: no PEM block found")
+	}
+
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("invalid JWT public key: not an RSA key")
+	}
+
+	rsaKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT public key: %w", err)
+	}
+	return rsaKey, nil
+}
+
+// loadJWKS reads the RSA keys from a JWKS document
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS modulus for key %q: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS exponent for key %q: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file contains no RSA keys")
+	}
+	return keys, nil
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testHMACSecret = "test-secret"
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(t *testing.T, secret string, header, claims map[string]interface{}) string {
+	t.Helper()
+	signingInput := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	signingInput := encodeSegment(t, map[string]interface{}{"alg": "RS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"sub":    "user_1",
+		"org_id": "org_1",
+		"iss":    "https://issuer.test",
+		"aud":    "cagen-quota",
+		"iat":    now.Add(-time.Hour).Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+		"jti":    "token-id",
+	}
+}
+
+func withClaims(overrides map[string]interface{}) map[string]interface{} {
+	claims := validClaims()
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestJWTVerifierHS256(t *testing.T) {
+	verifier, err := NewJWTVerifier(JWTConfig{
+		HMACSecret: testHMACSecret,
+		Issuer:     "https://issuer.test",
+		Audience:   "cagen-quota",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs256 := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "valid", token: signHS256(t, testHMACSecret, hs256, validClaims())},
+		{name: "audience list", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"aud": []string{"other", "cagen-quota"}}))},
+		{name: "alg none", token: encodeSegment(t, map[string]interface{}{"alg": "none"}) + "." + encodeSegment(t, validClaims()) + ".", wantErr: ErrInvalidToken},
+		{name: "alg HS512", token: signHS256(t, testHMACSecret, map[string]interface{}{"alg": "HS512"}, validClaims()), wantErr: ErrInvalidToken},
+		{name: "RS256 not configured", token: signHS256(t, testHMACSecret, map[string]interface{}{"alg": "RS256"}, validClaims()), wantErr: ErrInvalidToken},
+		{name: "wrong secret", token: signHS256(t, "other-secret", hs256, validClaims()), wantErr: ErrInvalidToken},
+		{name: "malformed", token: "not-a-jwt", wantErr: ErrInvalidToken},
+		{name: "expired", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), wantErr: ErrTokenExpired},
+		{name: "expired within leeway", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()}))},
+		{name: "missing exp", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"exp": nil})), wantErr: ErrInvalidToken},
+		{name: "not yet valid", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), wantErr: ErrInvalidToken},
+		{name: "wrong audience", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"aud": "other"})), wantErr: ErrInvalidToken},
+		{name: "audience list without ours", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"aud": []string{"a", "b"}})), wantErr: ErrInvalidToken},
+		{name: "missing audience", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"aud": nil})), wantErr: ErrInvalidToken},
+		{name: "wrong issuer", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"iss": "https://evil.test"})), wantErr: ErrInvalidToken},
+		{name: "missing subject", token: signHS256(t, testHMACSecret, hs256, withClaims(map[string]interface{}{"sub": nil})), wantErr: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userInfo, err := verifier.Verify(tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Verify() error = %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if userInfo.UserID != "user_1" || userInfo.OrganizationID != "org_1" {
+				t.Fatalf("Verify() = %+v", userInfo)
+			}
+			// jti and iat belong to the issuer and must not become the
+			// outbound nonce and timestamp
+			if userInfo.Nonce != "" || userInfo.Timestamp != 0 {
+				t.Fatalf("Verify() mapped jti/iat onto UserInfo: %+v", userInfo)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewJWTVerifier(JWTConfig{PublicKeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Verify(signRS256(t, key, validClaims())); err != nil {
+		t.Fatalf("Verify() of a valid RS256 token error = %v", err)
+	}
+	if _, err := verifier.Verify(signRS256(t, otherKey, validClaims())); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() with another key error = %v; want %v", err, ErrInvalidToken)
+	}
+
+	// HS256 must not be accepted when only an RSA key is configured, even if
+	// the token is "signed" with the public key bytes
+	hs256 := signHS256(t, string(der), map[string]interface{}{"alg": "HS256"}, validClaims())
+	if _, err := verifier.Verify(hs256); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() of an HS256 token error = %v; want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestNewJWTVerifierRequiresKey(t *testing.T) {
+	if _, err := NewJWTVerifier(JWTConfig{Issuer: "https://issuer.test"}); err == nil {
+		t.Fatal("NewJWTVerifier() without key material should fail")
+	}
+}
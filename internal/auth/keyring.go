@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SharedKeySize is the required length of a shared key (AES-256)
+const SharedKeySize = 32
+
+// envelopeSeparator separates the key ID from the base64 ciphertext.
+// It is not part of the standard base64 alphabet, so untagged legacy
+// payloads never contain it.
+const envelopeSeparator = "."
+
+// Keyring holds the ID-tagged shared keys known to the service. New payloads
+// are encrypted with the primary key; any key in the ring may decrypt.
+type Keyring struct {
+	mu        sync.RWMutex
+	keys      map[string][]byte
+	primaryID string
+}
+
+// NewKeyring creates an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string][]byte),
+	}
+}
+
+// KeyID derives a stable identifier for a key that was configured without one
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "k" + hex.EncodeToString(sum[:4])
+}
+
+// Add adds a key to the ring. The first key added becomes the primary.
+func (kr *Keyring) Add(id string, key []byte) error {
+	if id == "" || strings.Contains(id, envelopeSeparator) {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+	if len(key) != SharedKeySize {
+		return fmt.Errorf("key %s must be %d bytes, got %d", id, SharedKeySize, len(key))
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, exists := kr.keys[id]; exists {
+		return fmt.Errorf("key %s already exists", id)
+	}
+	kr.keys[id] = append([]byte(nil), key...)
+	if kr.primaryID == "" {
+		kr.primaryID = id
+	}
+	return nil
+}
+
+// SetPrimary selects the key used for encryption
+func (kr *Keyring) SetPrimary(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, exists := kr.keys[id]; !exists {
+		return fmt.Errorf("key %s not found", id)
+	}
+	kr.primaryID = id
+	return nil
+}
+
+// Remove retires a key. The primary key cannot be removed.
+func (kr *Keyring) Remove(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if _, exists := kr.keys[id]; !exists {
+		return fmt.Errorf("key %s not found", id)
+	}
+	if id == kr.primaryID {
+		return fmt.Errorf("cannot remove primary key %s", id)
+	}
+	delete(kr.keys, id)
+	return nil
+}
+
+// Primary returns the primary key and its ID
+func (kr *Keyring) Primary() (string, []byte, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	if kr.primaryID == "" {
+		return "", nil, fmt.Errorf("shared key not configured")
+	}
+	return kr.primaryID, kr.keys[kr.primaryID], nil
+}
+
+// Get returns the key with the given ID
+func (kr *Keyring) Get(id string) ([]byte, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	key, exists := kr.keys[id]
+	return key, exists
+}
+
+// IDs returns all key IDs, primary first
+func (kr *Keyring) IDs() []string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	ids := make([]string, 0, len(kr.keys))
+	for id := range kr.keys {
+		if id != kr.primaryID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if kr.primaryID != "" {
+		ids = append([]string{kr.primaryID}, ids...)
+	}
+	return ids
+}
+
+// Len returns the number of keys in the ring
+func (kr *Keyring) Len() int {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return len(kr.keys)
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyring(t *testing.T) {
+	keyring := NewKeyring()
+
+	if _, _, err := keyring.Primary(); err == nil {
+		t.Fatal("Primary() on an empty ring should fail")
+	}
+	if err := keyring.Add("k1", testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []struct {
+		name string
+		id   string
+		key  []byte
+	}{
+		{name: "duplicate ID", id: "k1", key: testKey(3)},
+		{name: "empty ID", id: "", key: testKey(3)},
+		{name: "ID with separator", id: "k.3", key: testKey(3)},
+		{name: "short key", id: "k3", key: []byte("short")},
+	}
+	for _, tt := range invalid {
+		if err := keyring.Add(tt.id, tt.key); err == nil {
+			t.Errorf("Add() with %s should fail", tt.name)
+		}
+	}
+
+	if id, _, _ := keyring.Primary(); id != "k1" {
+		t.Fatalf("first key added should be primary, got %s", id)
+	}
+	if err := keyring.Remove("k1"); err == nil {
+		t.Fatal("Remove() of the primary key should fail")
+	}
+	if err := keyring.SetPrimary("missing"); err == nil {
+		t.Fatal("SetPrimary() of an unknown key should fail")
+	}
+	if err := keyring.SetPrimary("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := keyring.IDs(); len(ids) != 2 || ids[0] != "k2" {
+		t.Fatalf("IDs() = %v; want primary k2 first", ids)
+	}
+	if err := keyring.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if keyring.Len() != 1 {
+		t.Fatalf("Len() = %d; want 1", keyring.Len())
+	}
+}
+
+func TestRotateServiceKey(t *testing.T) {
+	var configured []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		configured = append(configured, body["key_id"])
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, map[string][]byte{"k1": testKey(1), "k2": testKey(2)}, "k1")
+	client.authBaseURL = server.URL
+
+	// Payloads under the old primary keep decrypting after rotation
+	oldPayload, err := client.EncryptUserInfo(&UserInfo{UserID: "user_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.RotateServiceKey("k3"); err == nil {
+		t.Fatal("RotateServiceKey() to a key missing from the config should fail")
+	}
+	if err := client.RotateServiceKey("k2"); err != nil {
+		t.Fatalf("RotateServiceKey() error = %v", err)
+	}
+
+	if len(configured) != 1 || configured[0] != "k2" {
+		t.Fatalf("auth service saw key IDs %v; want [k2]", configured)
+	}
+	if id, _, _ := client.Keyring().Primary(); id != "k2" {
+		t.Fatalf("primary = %s; want k2", id)
+	}
+	if _, err := client.DecryptUserInfo(oldPayload); err != nil {
+		t.Fatalf("DecryptUserInfo() of a pre-rotation payload error = %v", err)
+	}
+}
+
+func TestDecryptUserInfoWithKeyring(t *testing.T) {
+	primary, other := testKey(1), testKey(2)
+
+	tests := []struct {
+		name    string
+		data    func(t *testing.T, userInfo *UserInfo) string
+		wantErr error
+	}{
+		{
+			name: "tagged payload with a non-primary key",
+			data: func(t *testing.T, userInfo *UserInfo) string { return "k2." + sealUserInfo(t, other, userInfo) },
+		},
+		{
+			name: "legacy payload with a non-primary key",
+			data: func(t *testing.T, userInfo *UserInfo) string { return sealUserInfo(t, other, userInfo) },
+		},
+		{
+			name:    "tagged with the wrong key ID",
+			data:    func(t *testing.T, userInfo *UserInfo) string { return "k1." + sealUserInfo(t, other, userInfo) },
+			wantErr: ErrInvalidPayload,
+		},
+		{
+			name:    "tagged with an unknown key ID",
+			data:    func(t *testing.T, userInfo *UserInfo) string { return "k9." + sealUserInfo(t, other, userInfo) },
+			wantErr: ErrInvalidPayload,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, map[string][]byte{"k1": primary, "k2": other}, "k1")
+			userInfo := &UserInfo{UserID: "user_1", Timestamp: time.Now().UnixMilli(), Nonce: "n-" + tt.name}
+			_, err := client.DecryptUserInfo(tt.data(t, userInfo))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("DecryptUserInfo() error = %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecryptUserInfo() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptUserInfoEnvelope(t *testing.T) {
+	client := newTestClient(t, map[string][]byte{"k1": testKey(1)}, "k1")
+	userInfo := &UserInfo{UserID: "user_1"}
+
+	legacy, err := client.EncryptUserInfo(userInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(legacy, envelopeSeparator) {
+		t.Fatalf("legacy payload %q carries a key ID", legacy)
+	}
+
+	client.SetKeyEnvelope(true)
+	tagged, err := client.EncryptUserInfo(userInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tagged, "k1"+envelopeSeparator) {
+		t.Fatalf("tagged payload %q does not start with the primary key ID", tagged)
+	}
+	if _, err := client.DecryptUserInfo(tagged); err != nil {
+		t.Fatalf("DecryptUserInfo(tagged) error = %v", err)
+	}
+}
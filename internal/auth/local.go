@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// LocalAuthorizer evaluates permissions from the local quota_permissions table
+// instead of the remote auth service. Quota owners implicitly hold the owner
+// permission; inheritance down the quota path is resolved by the caller.
+type LocalAuthorizer struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+var _ Authorizer = (*LocalAuthorizer)(nil)
+
+// NewLocalAuthorizer creates a new local permission engine
+func NewLocalAuthorizer(db *sql.DB, logger *logrus.Logger) *LocalAuthorizer {
+	return &LocalAuthorizer{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// impliedPermissions lists the permissions each permission implies
+var impliedPermissions = map[string][]string{
+	QuotaPermissionOwner: {QuotaPermissionOwner, QuotaPermissionAdmin, QuotaPermissionRead},
+	QuotaPermissionAdmin: {QuotaPermissionAdmin, QuotaPermissionRead},
+	QuotaPermissionRead:  {QuotaPermissionRead},
+}
+
+// CheckPermission implements Authorizer
+func (la *LocalAuthorizer) CheckPermission(userInfo *UserInfo, resourceID string, permissions []string) (bool, error) {
+	result, err := la.ResolvePermissions(userInfo, resourceID, permissions)
+	if err != nil {
+		return false, err
+	}
+
+	if !result.HasAll(permissions) {
+		la.logger.WithFields(logrus.Fields{
+			"user_id":     userInfo.UserID,
+			"resource_id": resourceID,
+			"permissions": permissions,
+		}).Debug("Permission check failed")
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// ResolvePermissions implements Authorizer
+func (la *LocalAuthorizer) ResolvePermissions(userInfo *UserInfo, resourceID string, permissions []string) (*PermissionResult, error) {
+	query := `
+		SELECT permission, effect FROM quota_permissions
+		WHERE user_id = $1 AND resource_id = $2
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		UNION
+		SELECT $3::VARCHAR, $4::VARCHAR FROM quotas
+		WHERE owner_id = $1 AND id = $2
+	`
+
+	rows, err := la.db.Query(query, userInfo.UserID, resourceID, QuotaPermissionOwner, PermissionEffectAllow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %w", err)
+	}
+	defer rows.Close()
+
+	granted := make(map[string]bool)
+	denied := make(map[string]bool)
+	for rows.Next() {
+		var perm, effect string
+		if err := rows.Scan(&perm, &effect); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		if effect == PermissionEffectDeny {
+			// A deny also covers every permission that implies the denied
+			// one, so deny(read) cannot be bypassed through an admin grant
+			// inherited from an ancestor
+			denied[perm] = true
+			for holder, implied := range impliedPermissions {
+				if containsString(implied, perm) {
+					denied[holder] = true
+				}
+			}
+			continue
+		}
+		for _, implied := range impliedPermissions[perm] {
+			granted[implied] = true
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating permission rows: %w", err)
+	}
+
+	result := &PermissionResult{
+		UserID:         userInfo.UserID,
+		ResourceID:     resourceID,
+		ResourceExists: true,
+	}
+	for perm := range granted {
+		if !denied[perm] {
+			result.GrantedPermissions = append(result.GrantedPermissions, perm)
+		}
+	}
+	for perm := range denied {
+		result.ExplicitlyDeniedPermissions = append(result.ExplicitlyDeniedPermissions, perm)
+	}
+
+	return result, nil
+}
+
+// GrantPermission implements Authorizer. The caller is responsible for
+// checking that the granting user may manage the resource.
+func (la *LocalAuthorizer) GrantPermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string, expiresAt *int64) error {
+	return la.upsertPermissions(adminUserInfo.UserID, targetUserID, resourceID, permissions, PermissionEffectAllow, expiresAt)
+}
+
+// DenyPermission implements Authorizer
+func (la *LocalAuthorizer) DenyPermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string, expiresAt *int64) error {
+	return la.upsertPermissions(adminUserInfo.UserID, targetUserID, resourceID, permissions, PermissionEffectDeny, expiresAt)
+}
+
+// RevokePermission implements Authorizer
+func (la *LocalAuthorizer) RevokePermission(adminUserInfo *UserInfo, targetUserID, resourceID string, permissions []string) error {
+	query := `DELETE FROM quota_permissions WHERE resource_id = $1 AND user_id = $2 AND permission = ANY($3)`
+
+	result, err := la.db.Exec(query, resourceID, targetUserID, pq.Array(permissions))
+	if err != nil {
+		return fmt.Errorf("failed to revoke permissions: %w", err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("permission revoke failed: no matching permissions found")
+	}
+
+	return nil
+}
+
+// ListPermissions implements Authorizer
+func (la *LocalAuthorizer) ListPermissions(userInfo *UserInfo, resourceID string) ([]PermissionEntry, error) {
+	query := `
+		SELECT user_id, permission, effect, granted_by, expires_at
+		FROM quota_permissions
+		WHERE resource_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY user_id, permission
+	`
+
+	rows, err := la.db.Query(query, resourceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []PermissionEntry{}
+	for rows.Next() {
+		entry := PermissionEntry{ResourceID: resourceID}
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&entry.UserID, &entry.Permission, &entry.Effect, &entry.GrantedBy, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %w", err)
+		}
+		if expiresAt.Valid {
+			ms := expiresAt.Time.UnixMilli()
+			entry.ExpiresAt = &ms
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating permission rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+// CreateResource implements Authorizer by granting owner to the creator
+func (la *LocalAuthorizer) CreateResource(userInfo *UserInfo, resourceID, resourceType, displayName, description string) error {
+	return la.upsertPermissions(userInfo.UserID, userInfo.UserID, resourceID, []string{QuotaPermissionOwner}, PermissionEffectAllow, nil)
+}
+
+// DeleteResource implements Authorizer by dropping every grant on the resource
+func (la *LocalAuthorizer) DeleteResource(userInfo *UserInfo, resourceID string) error {
+	if _, err := la.db.Exec(`DELETE FROM quota_permissions WHERE resource_id = $1`, resourceID); err != nil {
+		return fmt.Errorf("failed to delete resource permissions: %w", err)
+	}
+	return nil
+}
+
+// InvalidatePermissions implements Authorizer; local decisions are not cached
+func (la *LocalAuthorizer) InvalidatePermissions(resourceID string) {}
+
+func (la *LocalAuthorizer) upsertPermissions(grantedBy, userID, resourceID string, permissions []string, effect string, expiresAt *int64) error {
+	for _, perm := range permissions {
+		if _, known := impliedPermissions[perm]; !known {
+			return fmt.Errorf("unknown permission: %s", perm)
+		}
+	}
+
+	var expiresAtTime *time.Time
+	if expiresAt != nil {
+		t := time.UnixMilli(*expiresAt)
+		expiresAtTime = &t
+	}
+
+	query := `
+		INSERT INTO quota_permissions (resource_id, user_id, permission, effect, granted_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (resource_id, user_id, permission)
+		DO UPDATE SET effect = EXCLUDED.effect, granted_by = EXCLUDED.granted_by,
+		              expires_at = EXCLUDED.expires_at, created_at = NOW()
+	`
+
+	for _, perm := range permissions {
+		if _, err := la.db.Exec(query, resourceID, userID, perm, effect, grantedBy, expiresAtTime); err != nil {
+			return fmt.Errorf("failed to store %s permission: %w", perm, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NonceStore remembers the nonces of accepted payloads to detect replays
+type NonceStore interface {
+	// Remember records nonce until expiresAt. It returns false if the nonce
+	// is already recorded and has not expired yet.
+	Remember(nonce string, expiresAt time.Time) (bool, error)
+}
+
+// nonceSweepInterval bounds how often expired nonces are purged
+const nonceSweepInterval = time.Minute
+
+// MemoryNonceStore keeps nonces in process memory (single replica only)
+type MemoryNonceStore struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewMemoryNonceStore creates a new in-memory nonce store
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Remember implements NonceStore
+func (s *MemoryNonceStore) Remember(nonce string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.nextSweep) {
+		for n, until := range s.entries {
+			if !until.After(now) {
+				delete(s.entries, n)
+			}
+		}
+		s.nextSweep = now.Add(nonceSweepInterval)
+	}
+
+	if until, exists := s.entries[nonce]; exists && until.After(now) {
+		return false, nil
+	}
+
+	s.entries[nonce] = expiresAt
+	return true, nil
+}
+
+// PostgresNonceStore keeps nonces in the auth_nonces table so that every
+// replica sees the same set
+type PostgresNonceStore struct {
+	db     *sql.DB
+	logger *logrus.Logger
+
+	mu        sync.Mutex
+	nextSweep time.Time
+}
+
+// NewPostgresNonceStore creates a new Postgres-backed nonce store
+func NewPostgresNonceStore(db *sql.DB, logger *logrus.Logger) *PostgresNonceStore {
+	return &PostgresNonceStore{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Remember implements NonceStore
+func (s *PostgresNonceStore) Remember(nonce string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+	s.purgeExpired(now)
+
+	// Insert the nonce, or take over an expired row with the same value
+	query := `
+		INSERT INTO auth_nonces (nonce, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (nonce) DO UPDATE SET expires_at = EXCLUDED.expires_at
+		WHERE auth_nonces.expires_at <= $3
+	`
+
+	result, err := s.db.Exec(query, nonce, expiresAt, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to store nonce: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to store nonce: %w", err)
+	}
+
+	return rows == 1, nil
+}
+
+// purgeExpired deletes expired nonces at most once per sweep interval
+func (s *PostgresNonceStore) purgeExpired(now time.Time) {
+	s.mu.Lock()
+	if now.Before(s.nextSweep) {
+		s.mu.Unlock()
+		return
+	}
+	s.nextSweep = now.Add(nonceSweepInterval)
+	s.mu.Unlock()
+
+	if _, err := s.db.Exec(`DELETE FROM auth_nonces WHERE expires_at <= $1`, now); err != nil {
+		s.logger.WithError(err).Warn("Failed to purge expired nonces")
+	}
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryNonceStore(t *testing.T) {
+	store := NewMemoryNonceStore()
+	future := time.Now().Add(time.Minute)
+
+	if fresh, _ := store.Remember("n1", future); !fresh {
+		t.Fatal("first Remember() should report fresh")
+	}
+	if fresh, _ := store.Remember("n1", future); fresh {
+		t.Fatal("second Remember() before expiry should report a replay")
+	}
+	if fresh, _ := store.Remember("n2", time.Now().Add(-time.Minute)); !fresh {
+		t.Fatal("Remember() of a new nonce should report fresh")
+	}
+	if fresh, _ := store.Remember("n2", future); !fresh {
+		t.Fatal("Remember() after the earlier entry expired should report fresh")
+	}
+}
@@ -18,9 +18,43 @@ type Config struct {
 	Environment string
 
 	// Auth Service Integration
-	AuthServiceURL         string
-	QuotaServiceSecretKey  string
-	QuotaServiceID         string
+	AuthServiceURL        string
+	QuotaServiceSecretKey string
+	QuotaServiceID        string
+
+	// Shared key rotation
+	QuotaServiceSecretKeys   string // Comma-separated list of key_id:base64_key
+	QuotaServicePrimaryKeyID string
+	AuthKeyEnvelope          bool   // Tag outbound payloads with the key ID
+	AdminAPIToken            string // Enables /admin routes when set
+
+	// Permission decision cache (0 disables)
+	PermissionCacheSize int
+
+	// Authorization enforcement: enforce | audit-only | off
+	AuthzMode string
+
+	// Permission engine: remote (cagen-auth-service) | local (quota_permissions table)
+	Authorizer string
+
+	// Bearer token (JWT) authentication; disabled when no key is set
+	JWTHMACSecret    string // HS256 shared secret
+	JWTPublicKeyFile string // PEM RSA public key for RS256
+	JWTJWKSFile      string // JWKS file with RS256 keys
+	JWTIssuer        string
+	JWTAudience      string
+
+	// Encrypted payload validation
+	AuthMaxClockSkewSeconds int
+	NonceStore              string // memory | postgres
+
+	// Usage reservations
+	ReservationTTLSeconds    int // default hold time
+	ReservationMaxTTLSeconds int
+	ReservationReaperSeconds int // interval between reaper passes (0 disables)
+
+	// Idempotency keys
+	IdempotencyKeyTTLSeconds int // retention window of stored keys
 
 	// Logging
 	LogLevel  string
@@ -30,7 +64,7 @@ type Config struct {
 	RailwayProjectID     string
 	RailwayEnvironmentID string
 	RailwayServiceID     string
-	
+
 	// CORS
 	AllowedOrigins string // Comma-separated list of allowed origins
 }
@@ -42,24 +76,52 @@ func Load() *Config {
 	}
 
 	config := &Config{
-		DatabaseURL:            getEnv("DATABASE_URL", "postgresql://localhost:5432/cagen_quota?sslmode=disable"),
-		Port:                   getEnv("PORT", "8080"),
-		GinMode:                getEnv("GIN_MODE", "debug"),
-		Environment:            getEnv("ENVIRONMENT", "development"),
-		AuthServiceURL:         getEnv("AUTH_SERVICE_URL", "https://cagen-auth-service-production.up.railway.app"),
-		QuotaServiceSecretKey:  getEnv("CAGEN_QUOTA_SERVICE_SECRET_KEY", ""),
-		QuotaServiceID:         getEnv("QUOTA_SERVICE_ID", "svc_cagen_quota"),
-		LogLevel:               getEnv("LOG_LEVEL", "info"),
-		LogFormat:              getEnv("LOG_FORMAT", "text"),
-		RailwayProjectID:       getEnv("RAILWAY_PROJECT_ID", ""),
-		RailwayEnvironmentID:   getEnv("RAILWAY_ENVIRONMENT_ID", ""),
-		RailwayServiceID:       getEnv("RAILWAY_SERVICE_ID", ""),
-		AllowedOrigins:         getEnv("ALLOWED_ORIGINS", "https://cyberagent-frontend.vercel.app,http://localhost:3000,http://localhost:3001,http://172.171.97.248:1088"),
+		DatabaseURL:              getEnv("DATABASE_URL", "postgresql://localhost:5432/cagen_quota?sslmode=disable"),
+		Port:                     getEnv("PORT", "8080"),
+		GinMode:                  getEnv("GIN_MODE", "debug"),
+		Environment:              getEnv("ENVIRONMENT", "development"),
+		AuthServiceURL:           getEnv("AUTH_SERVICE_URL", "https://cagen-auth-service-production.up.railway.app"),
+		QuotaServiceSecretKey:    getEnv("CAGEN_QUOTA_SERVICE_SECRET_KEY", ""),
+		QuotaServiceID:           getEnv("QUOTA_SERVICE_ID", "svc_cagen_quota"),
+		QuotaServiceSecretKeys:   getEnv("CAGEN_QUOTA_SERVICE_SECRET_KEYS", ""),
+		QuotaServicePrimaryKeyID: getEnv("CAGEN_QUOTA_SERVICE_PRIMARY_KEY_ID", ""),
+		AuthKeyEnvelope:          getEnvAsBool("AUTH_KEY_ENVELOPE", false),
+		AdminAPIToken:            getEnv("ADMIN_API_TOKEN", ""),
+		PermissionCacheSize:      getEnvAsInt("PERMISSION_CACHE_SIZE", 10000),
+		AuthzMode:                getEnv("AUTHZ_MODE", "enforce"),
+		Authorizer:               getEnv("AUTHORIZER", "remote"),
+		JWTHMACSecret:            getEnv("JWT_HMAC_SECRET", ""),
+		JWTPublicKeyFile:         getEnv("JWT_PUBLIC_KEY_FILE", ""),
+		JWTJWKSFile:              getEnv("JWT_JWKS_FILE", ""),
+		JWTIssuer:                getEnv("JWT_ISSUER", ""),
+		JWTAudience:              getEnv("JWT_AUDIENCE", ""),
+		AuthMaxClockSkewSeconds:  getEnvAsInt("AUTH_MAX_CLOCK_SKEW_SECONDS", 300),
+		NonceStore:               getEnv("NONCE_STORE", "memory"),
+		ReservationTTLSeconds:    getEnvAsInt("RESERVATION_DEFAULT_TTL_SECONDS", 300),
+		ReservationMaxTTLSeconds: getEnvAsInt("RESERVATION_MAX_TTL_SECONDS", 3600),
+		ReservationReaperSeconds: getEnvAsInt("RESERVATION_REAPER_INTERVAL_SECONDS", 30),
+		IdempotencyKeyTTLSeconds: getEnvAsInt("IDEMPOTENCY_KEY_TTL_SECONDS", 86400),
+		LogLevel:                 getEnv("LOG_LEVEL", "info"),
+		LogFormat:                getEnv("LOG_FORMAT", "text"),
+		RailwayProjectID:         getEnv("RAILWAY_PROJECT_ID", ""),
+		RailwayEnvironmentID:     getEnv("RAILWAY_ENVIRONMENT_ID", ""),
+		RailwayServiceID:         getEnv("RAILWAY_SERVICE_ID", ""),
+		AllowedOrigins:           getEnv("ALLOWED_ORIGINS", "https://cyberagent-frontend.vercel.app,http://localhost:3000,http://localhost:3001,http://172.171.97.248:1088"),
 	}
 
 	// Validate required configs
-	if config.QuotaServiceSecretKey == "" && config.Environment == "production" {
-		logrus.Fatal("CAGEN_QUOTA_SERVICE_SECRET_KEY is required in production")
+	if config.QuotaServiceSecretKey == "" && config.QuotaServiceSecretKeys == "" && config.Environment == "production" {
+		logrus.Fatal("CAGEN_QUOTA_SERVICE_SECRET_KEY or CAGEN_QUOTA_SERVICE_SECRET_KEYS is required in production")
+	}
+
+	switch config.AuthzMode {
+	case "enforce", "audit-only":
+	case "off":
+		if config.Environment == "production" {
+			logrus.Fatal("AUTHZ_MODE=off is not allowed in production")
+		}
+	default:
+		logrus.Fatalf("Invalid AUTHZ_MODE %q (expected enforce, audit-only or off)", config.AuthzMode)
 	}
 
 	return config
@@ -88,4 +150,4 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
@@ -83,6 +83,36 @@ func (db *DB) InitSchema() error {
 		-- Constraints
 		CONSTRAINT quota_balance_check CHECK (used_mb + allocated_mb <= total_mb)
 	);
+	-- Rollup accounting: usage also counts against every ancestor
+	ALTER TABLE quotas ADD COLUMN IF NOT EXISTS usage_mode VARCHAR(20) NOT NULL DEFAULT 'direct' CHECK (usage_mode IN ('direct', 'rollup'));
+	ALTER TABLE quotas ADD COLUMN IF NOT EXISTS subtree_used_mb BIGINT NOT NULL DEFAULT 0 CHECK (subtree_used_mb >= 0);
+
+	-- Overcommit: a quota may hand out up to total_mb * overcommit_ratio
+	ALTER TABLE quotas ADD COLUMN IF NOT EXISTS overcommit_ratio NUMERIC(6,3) NOT NULL DEFAULT 1 CHECK (overcommit_ratio >= 1);
+	-- Replace the balance check once; re-adding it on every start would lock
+	-- and re-validate the whole table.
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'quota_overcommit_check' AND conrelid = 'quotas'::regclass) THEN
+			ALTER TABLE quotas DROP CONSTRAINT IF EXISTS quota_balance_check;
+			ALTER TABLE quotas ADD CONSTRAINT quota_overcommit_check CHECK (used_mb + allocated_mb <= total_mb * overcommit_ratio);
+		END IF;
+	END
+	$$;
+
+	-- Warning limits: soft limit and threshold percentages of total_mb
+	ALTER TABLE quotas ADD COLUMN IF NOT EXISTS soft_limit_mb BIGINT CHECK (soft_limit_mb > 0);
+	ALTER TABLE quotas ADD COLUMN IF NOT EXISTS warning_thresholds INTEGER[] NOT NULL DEFAULT '{}';
+
+	-- Reservations: the part of used_mb held by active reservations
+	ALTER TABLE quotas ADD COLUMN IF NOT EXISTS reserved_mb BIGINT NOT NULL DEFAULT 0 CHECK (reserved_mb >= 0);
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'quota_reserved_check' AND conrelid = 'quotas'::regclass) THEN
+			ALTER TABLE quotas ADD CONSTRAINT quota_reserved_check CHECK (reserved_mb <= used_mb);
+		END IF;
+	END
+	$$;
 
 	-- Quota usage table
 	CREATE TABLE IF NOT EXISTS quota_usage (
@@ -96,6 +126,99 @@ func (db *DB) InitSchema() error {
 		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 	);
 
+	-- Usage rows may carry dimensions only. The original usage_mb > 0 check
+	-- is relaxed once, not on every start.
+	DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM pg_constraint
+			WHERE conname = 'quota_usage_usage_mb_check' AND conrelid = 'quota_usage'::regclass
+			  AND pg_get_constraintdef(oid) LIKE '%usage_mb > 0%'
+		) THEN
+			ALTER TABLE quota_usage DROP CONSTRAINT quota_usage_usage_mb_check;
+			ALTER TABLE quota_usage ADD CONSTRAINT quota_usage_usage_mb_check CHECK (usage_mb >= 0);
+		END IF;
+	END
+	$$;
+
+	-- Both rows written by a usage transfer share its transfer_id
+	ALTER TABLE quota_usage ADD COLUMN IF NOT EXISTS transfer_id VARCHAR(50);
+
+	-- Resource dimensions besides storage MB (CPU-seconds, tokens, invocations, ...)
+	CREATE TABLE IF NOT EXISTS quota_dimensions (
+		quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+		dimension VARCHAR(50) NOT NULL,
+		unit VARCHAR(20) NOT NULL,
+		total BIGINT NOT NULL CHECK (total >= 0),
+		used BIGINT NOT NULL DEFAULT 0 CHECK (used >= 0),
+		allocated BIGINT NOT NULL DEFAULT 0 CHECK (allocated >= 0),
+		subtree_used BIGINT NOT NULL DEFAULT 0 CHECK (subtree_used >= 0),
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (quota_id, dimension),
+		CONSTRAINT quota_dimension_balance_check CHECK (used + allocated <= total)
+	);
+
+	-- Dimension amounts of each usage row
+	CREATE TABLE IF NOT EXISTS quota_usage_dimensions (
+		usage_id VARCHAR(50) NOT NULL REFERENCES quota_usage(id),
+		dimension VARCHAR(50) NOT NULL,
+		amount BIGINT NOT NULL CHECK (amount > 0),
+		PRIMARY KEY (usage_id, dimension)
+	);
+
+	-- Two-phase usage: capacity held until committed, cancelled or expired
+	CREATE TABLE IF NOT EXISTS quota_reservations (
+		id VARCHAR(50) PRIMARY KEY,
+		quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+		user_id VARCHAR(255) NOT NULL,
+		resource_id VARCHAR(255) NOT NULL,
+		usage_mb BIGINT NOT NULL CHECK (usage_mb > 0),
+		status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'committed', 'cancelled', 'expired')),
+		reason TEXT,
+		usage_id VARCHAR(50) REFERENCES quota_usage(id),
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		resolved_at TIMESTAMP WITH TIME ZONE
+	);
+
+	-- Current usage balance per (quota, resource). When the table is first
+	-- created it is backfilled from the quota_usage history.
+	DO $$
+	BEGIN
+		IF to_regclass('quota_resource_usage') IS NULL THEN
+			CREATE TABLE quota_resource_usage (
+				quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+				resource_id VARCHAR(255) NOT NULL,
+				used_mb BIGINT NOT NULL DEFAULT 0 CHECK (used_mb >= 0),
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+				PRIMARY KEY (quota_id, resource_id)
+			);
+			INSERT INTO quota_resource_usage (quota_id, resource_id, used_mb)
+			SELECT u.quota_id, u.resource_id,
+			       SUM(CASE WHEN u.operation = 'allocate' THEN u.usage_mb ELSE -u.usage_mb END)
+			FROM quota_usage u
+			JOIN quotas q ON q.id = u.quota_id AND q.status != 'deleted'
+			WHERE u.resource_id IS NOT NULL
+			GROUP BY u.quota_id, u.resource_id
+			HAVING SUM(CASE WHEN u.operation = 'allocate' THEN u.usage_mb ELSE -u.usage_mb END) > 0;
+		END IF;
+	END
+	$$;
+
+	-- Results of usage requests made with an idempotency key
+	CREATE TABLE IF NOT EXISTS quota_idempotency_keys (
+		quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+		idempotency_key VARCHAR(255) NOT NULL,
+		operation VARCHAR(20) NOT NULL CHECK (operation IN ('allocate', 'deallocate')),
+		request_hash VARCHAR(64) NOT NULL,
+		usage_id VARCHAR(50) NOT NULL REFERENCES quota_usage(id),
+		response JSONB,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (quota_id, idempotency_key)
+	);
+
 	-- Quota audit logs table
 	CREATE TABLE IF NOT EXISTS quota_audit_logs (
 		id VARCHAR(50) PRIMARY KEY,
@@ -107,6 +230,25 @@ func (db *DB) InitSchema() error {
 		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 	);
 
+	-- Local permission grants (AUTHORIZER=local)
+	CREATE TABLE IF NOT EXISTS quota_permissions (
+		resource_id VARCHAR(50) NOT NULL,
+		user_id VARCHAR(255) NOT NULL,
+		permission VARCHAR(20) NOT NULL CHECK (permission IN ('read', 'admin', 'owner')),
+		effect VARCHAR(10) NOT NULL DEFAULT 'allow' CHECK (effect IN ('allow', 'deny')),
+		granted_by VARCHAR(255) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (resource_id, user_id, permission)
+	);
+	ALTER TABLE quota_permissions ADD COLUMN IF NOT EXISTS effect VARCHAR(10) NOT NULL DEFAULT 'allow' CHECK (effect IN ('allow', 'deny'));
+	ALTER TABLE quota_permissions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+	-- Nonces of accepted encrypted payloads (replay protection)
+	CREATE TABLE IF NOT EXISTS auth_nonces (
+		nonce VARCHAR(255) PRIMARY KEY,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
 	-- Indexes for performance
 	CREATE INDEX IF NOT EXISTS idx_quotas_parent ON quotas(parent_quota_id);
 	CREATE INDEX IF NOT EXISTS idx_quotas_organization ON quotas(organization_id);
@@ -119,11 +261,21 @@ func (db *DB) InitSchema() error {
 	CREATE INDEX IF NOT EXISTS idx_quota_usage_user ON quota_usage(user_id);
 	CREATE INDEX IF NOT EXISTS idx_quota_usage_resource ON quota_usage(resource_id);
 	CREATE INDEX IF NOT EXISTS idx_quota_usage_created ON quota_usage(created_at);
+	CREATE INDEX IF NOT EXISTS idx_quota_usage_transfer ON quota_usage(transfer_id) WHERE transfer_id IS NOT NULL;
+
+	CREATE INDEX IF NOT EXISTS idx_quota_reservations_quota ON quota_reservations(quota_id);
+	CREATE INDEX IF NOT EXISTS idx_quota_reservations_active_expiry ON quota_reservations(expires_at) WHERE status = 'active';
+
+	CREATE INDEX IF NOT EXISTS idx_quota_idempotency_keys_created ON quota_idempotency_keys(created_at);
 
 	CREATE INDEX IF NOT EXISTS idx_quota_audit_quota ON quota_audit_logs(quota_id);
 	CREATE INDEX IF NOT EXISTS idx_quota_audit_actor ON quota_audit_logs(actor_user_id);
 	CREATE INDEX IF NOT EXISTS idx_quota_audit_created ON quota_audit_logs(created_at);
 
+	CREATE INDEX IF NOT EXISTS idx_auth_nonces_expires ON auth_nonces(expires_at);
+
+	CREATE INDEX IF NOT EXISTS idx_quota_permissions_user ON quota_permissions(user_id);
+
 	-- Function to update updated_at timestamp
 	CREATE OR REPLACE FUNCTION update_updated_at_column()
 	RETURNS TRIGGER AS $$
@@ -150,8 +302,9 @@ func (db *DB) InitSchema() error {
 	return nil
 }
 
-// WithTransaction executes a function within a database transaction
-func (db *DB) WithTransaction(fn func(*sql.Tx) error) error {
+// WithTransaction executes a function within a database transaction. If the
+// commit fails, its error is returned.
+func (db *DB) WithTransaction(fn func(*sql.Tx) error) (err error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -163,8 +316,8 @@ func (db *DB) WithTransaction(fn func(*sql.Tx) error) error {
 			panic(p)
 		} else if err != nil {
 			tx.Rollback()
-		} else {
-			err = tx.Commit()
+		} else if commitErr := tx.Commit(); commitErr != nil {
+			err = fmt.Errorf("failed to commit transaction: %w", commitErr)
 		}
 	}()
 
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+var errCommitFailed = errors.New("commit failed")
+
+// stubDriver hands out connections whose transactions record how they ended
+// and fail to commit when commitErr is set
+type stubDriver struct {
+	commitErr  error
+	committed  int
+	rolledBack int
+}
+
+func (d *stubDriver) Open(string) (driver.Conn, error) { return &stubConn{driver: d}, nil }
+
+type stubConn struct{ driver *stubDriver }
+
+func (c *stubConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *stubConn) Close() error                        { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)           { return &stubTx{driver: c.driver}, nil }
+
+type stubTx struct{ driver *stubDriver }
+
+func (tx *stubTx) Commit() error {
+	tx.driver.committed++
+	return tx.driver.commitErr
+}
+
+func (tx *stubTx) Rollback() error {
+	tx.driver.rolledBack++
+	return nil
+}
+
+func newStubDB(t *testing.T, d *stubDriver) *DB {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	db := &DB{DB: sql.OpenDB(stubConnector{d}), logger: logger}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type stubConnector struct{ driver *stubDriver }
+
+func (c stubConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open("") }
+func (c stubConnector) Driver() driver.Driver                        { return c.driver }
+
+func TestWithTransaction(t *testing.T) {
+	errFn := errors.New("fn failed")
+
+	tests := []struct {
+		name           string
+		commitErr      error
+		fnErr          error
+		wantErr        error
+		wantCommitted  int
+		wantRolledBack int
+	}{
+		{name: "commits", wantCommitted: 1},
+		{name: "returns the commit error", commitErr: errCommitFailed, wantErr: errCommitFailed, wantCommitted: 1},
+		{name: "rolls back when fn fails", fnErr: errFn, wantErr: errFn, wantRolledBack: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &stubDriver{commitErr: tt.commitErr}
+			db := newStubDB(t, d)
+
+			err := db.WithTransaction(func(*sql.Tx) error { return tt.fnErr })
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("WithTransaction() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WithTransaction() error = %v; want %v", err, tt.wantErr)
+			}
+			if d.committed != tt.wantCommitted || d.rolledBack != tt.wantRolledBack {
+				t.Fatalf("committed %d, rolled back %d; want %d, %d",
+					d.committed, d.rolledBack, tt.wantCommitted, tt.wantRolledBack)
+			}
+		})
+	}
+}
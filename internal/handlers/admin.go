@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListServiceKeys handles requests to list the shared key IDs
+func (qh *QuotaHandler) ListServiceKeys(c *gin.Context) {
+	keyring := qh.authClient.Keyring()
+	primaryKeyID, _, err := keyring.Primary()
+	if err != nil {
+		qh.respondError(c, http.StatusInternalServerError, "Failed to list service keys", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Service keys listed successfully", gin.H{
+		"primary_key_id": primaryKeyID,
+		"key_ids":        keyring.IDs(),
+	})
+}
+
+// RotateServiceKey handles shared key rotation requests. The key must already
+// be configured in CAGEN_QUOTA_SERVICE_SECRET_KEYS; it is registered with the
+// auth service and becomes primary immediately. The change is not persisted:
+// CAGEN_QUOTA_SERVICE_PRIMARY_KEY_ID must be updated as well, or the old
+// primary is restored on restart.
+func (qh *QuotaHandler) RotateServiceKey(c *gin.Context) {
+	var request struct {
+		KeyID string `json:"key_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request format", err)
+		return
+	}
+
+	if _, exists := qh.authClient.Keyring().Get(request.KeyID); !exists {
+		qh.respondError(c, http.StatusBadRequest, "Failed to rotate service key", fmt.Errorf("key %s is not configured", request.KeyID))
+		return
+	}
+
+	if err := qh.authClient.RotateServiceKey(request.KeyID); err != nil {
+		qh.respondError(c, http.StatusBadGateway, "Failed to rotate service key", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Service key rotated successfully", gin.H{
+		"primary_key_id": request.KeyID,
+		"key_ids":        qh.authClient.Keyring().IDs(),
+	})
+}
+
+// RetireServiceKey handles requests to remove a non-primary shared key. The
+// change is not persisted; the key comes back on restart until it is removed
+// from CAGEN_QUOTA_SERVICE_SECRET_KEYS.
+func (qh *QuotaHandler) RetireServiceKey(c *gin.Context) {
+	keyID := c.Param("key_id")
+
+	if err := qh.authClient.RetireServiceKey(keyID); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Failed to retire service key", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Service key retired successfully", gin.H{
+		"key_ids": qh.authClient.Keyring().IDs(),
+	})
+}
+
+// PermissionCacheStats handles requests for permission cache counters
+func (qh *QuotaHandler) PermissionCacheStats(c *gin.Context) {
+	qh.respondSuccess(c, http.StatusOK, "Permission cache stats retrieved successfully", qh.authClient.PermissionCacheStats())
+}
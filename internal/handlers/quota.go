@@ -1,19 +1,24 @@
 package handlers
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/middleware"
 	"github.com/emagen-ai/cagen-quota/internal/models"
 	"github.com/emagen-ai/cagen-quota/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
 
+// IdempotencyKeyHeader makes usage allocate/deallocate requests safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 // QuotaHandler handles quota-related HTTP requests
 type QuotaHandler struct {
 	quotaService *services.QuotaService
@@ -38,12 +43,7 @@ func (qh *QuotaHandler) CreateQuota(c *gin.Context) {
 		return
 	}
 
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(request.ServiceID, request.EncryptedData)
-	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
-		return
-	}
+	userInfo := qh.currentUser(c)
 
 	// Create quota
 	quota, err := qh.quotaService.CreateQuota(userInfo, &request)
@@ -53,7 +53,7 @@ func (qh *QuotaHandler) CreateQuota(c *gin.Context) {
 			"name":    request.Name,
 			"type":    request.Type,
 		}).Error("Failed to create quota")
-		qh.respondError(c, http.StatusInternalServerError, "Failed to create quota", err)
+		qh.respondError(c, statusForServiceError(err), "Failed to create quota", err)
 		return
 	}
 
@@ -74,12 +74,7 @@ func (qh *QuotaHandler) AllocateQuota(c *gin.Context) {
 		return
 	}
 
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(request.ServiceID, request.EncryptedData)
-	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
-		return
-	}
+	userInfo := qh.currentUser(c)
 
 	// Allocate quota
 	childQuota, err := qh.quotaService.AllocateQuota(userInfo, parentQuotaID, &request)
@@ -89,7 +84,7 @@ func (qh *QuotaHandler) AllocateQuota(c *gin.Context) {
 			"parent_quota_id":  parentQuotaID,
 			"allocate_mb":      request.AllocateMB,
 		}).Error("Failed to allocate quota")
-		qh.respondError(c, http.StatusInternalServerError, "Failed to allocate quota", err)
+		qh.respondError(c, statusForServiceError(err), "Failed to allocate quota", err)
 		return
 	}
 
@@ -104,60 +99,201 @@ func (qh *QuotaHandler) ReleaseQuota(c *gin.Context) {
 		return
 	}
 
-	var request struct {
-		ServiceID     string `json:"service_id" binding:"required"`
-		EncryptedData string `json:"encrypted_data" binding:"required"`
-	}
-	if err := c.ShouldBindJSON(&request); err != nil {
+	// The body is optional for a plain release
+	var request models.QuotaReleaseRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
 		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
+	if c.Query("recursive") == "true" {
+		request.Recursive = true
+	}
+	if c.Query("dry_run") == "true" {
+		request.DryRun = true
+	}
+	if request.DryRun && !request.Recursive {
+		qh.respondError(c, http.StatusBadRequest, "dry_run requires recursive", nil)
+		return
+	}
 
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(request.ServiceID, request.EncryptedData)
-	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
+	userInfo := qh.currentUser(c)
+
+	if request.Recursive {
+		// Release quota subtree
+		plan, err := qh.quotaService.ReleaseQuotaTree(userInfo, quotaID, request.DryRun, request.Reason)
+		if err != nil {
+			qh.logger.WithError(err).WithFields(logrus.Fields{
+				"user_id":  userInfo.UserID,
+				"quota_id": quotaID,
+				"dry_run":  request.DryRun,
+			}).Error("Failed to release quota subtree")
+			qh.respondError(c, statusForServiceError(err), "Failed to release quota", err)
+			return
+		}
+
+		message := "Quota subtree released successfully"
+		if request.DryRun {
+			message = "Quota subtree release planned (dry run)"
+		}
+		qh.respondSuccess(c, http.StatusOK, message, plan)
 		return
 	}
 
 	// Release quota
-	err = qh.quotaService.ReleaseQuota(userInfo, quotaID)
+	err := qh.quotaService.ReleaseQuota(userInfo, quotaID)
 	if err != nil {
 		qh.logger.WithError(err).WithFields(logrus.Fields{
 			"user_id":  userInfo.UserID,
 			"quota_id": quotaID,
 		}).Error("Failed to release quota")
-		qh.respondError(c, http.StatusInternalServerError, "Failed to release quota", err)
+		qh.respondError(c, statusForServiceError(err), "Failed to release quota", err)
 		return
 	}
 
 	qh.respondSuccess(c, http.StatusOK, "Quota released successfully", nil)
 }
 
-// GetQuota handles quota retrieval requests
-func (qh *QuotaHandler) GetQuota(c *gin.Context) {
+// ResizeQuota handles quota resize requests
+func (qh *QuotaHandler) ResizeQuota(c *gin.Context) {
 	quotaID := c.Param("id")
 	if quotaID == "" {
 		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
 		return
 	}
 
-	// Get encrypted data from query params or headers
-	serviceID := c.Query("service_id")
-	encryptedData := c.Query("encrypted_data")
-	
-	if serviceID == "" || encryptedData == "" {
-		qh.respondError(c, http.StatusBadRequest, "service_id and encrypted_data are required", nil)
+	var request models.QuotaResizeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if request.TotalMB == 0 && request.OvercommitRatio == nil && len(request.Dimensions) == 0 {
+		qh.respondError(c, http.StatusBadRequest, "total_mb, overcommit_ratio or dimensions is required", nil)
 		return
 	}
 
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(serviceID, encryptedData)
+	userInfo := qh.currentUser(c)
+
+	// Resize quota
+	quota, err := qh.quotaService.ResizeQuota(userInfo, quotaID, &request)
 	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":          userInfo.UserID,
+			"quota_id":         quotaID,
+			"total_mb":         request.TotalMB,
+			"overcommit_ratio": request.OvercommitRatio,
+		}).Error("Failed to resize quota")
+		qh.respondError(c, statusForServiceError(err), "Failed to resize quota", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota resized successfully", quota)
+}
+
+// SuspendQuota handles quota suspend requests
+func (qh *QuotaHandler) SuspendQuota(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	// The body is optional when authenticating with a bearer token
+	var request models.QuotaStatusRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Suspend quota
+	response, err := qh.quotaService.SuspendQuota(userInfo, quotaID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userInfo.UserID,
+			"quota_id": quotaID,
+			"cascade":  request.Cascade,
+		}).Error("Failed to suspend quota")
+		qh.respondError(c, statusForServiceError(err), "Failed to suspend quota", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota suspended successfully", response)
+}
+
+// ResumeQuota handles quota resume requests
+func (qh *QuotaHandler) ResumeQuota(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	// The body is optional when authenticating with a bearer token
+	var request models.QuotaStatusRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Resume quota
+	response, err := qh.quotaService.ResumeQuota(userInfo, quotaID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userInfo.UserID,
+			"quota_id": quotaID,
+			"cascade":  request.Cascade,
+		}).Error("Failed to resume quota")
+		qh.respondError(c, statusForServiceError(err), "Failed to resume quota", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota resumed successfully", response)
+}
+
+// MoveQuota handles requests to re-parent a quota
+func (qh *QuotaHandler) MoveQuota(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	var request models.QuotaMoveRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Move quota
+	quota, err := qh.quotaService.MoveQuota(userInfo, quotaID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":             userInfo.UserID,
+			"quota_id":            quotaID,
+			"new_parent_quota_id": request.NewParentQuotaID,
+		}).Error("Failed to move quota")
+		qh.respondError(c, statusForServiceError(err), "Failed to move quota", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota moved successfully", quota)
+}
+
+// GetQuota handles quota retrieval requests
+func (qh *QuotaHandler) GetQuota(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
 		return
 	}
 
+	userInfo := qh.currentUser(c)
+
 	// Get quota
 	quota, err := qh.quotaService.GetQuota(userInfo, quotaID)
 	if err != nil {
@@ -178,6 +314,152 @@ func (qh *QuotaHandler) GetQuota(c *gin.Context) {
 	qh.respondSuccess(c, http.StatusOK, "Quota retrieved successfully", quota)
 }
 
+// GetQuotaTree handles requests for a quota's nested hierarchy
+func (qh *QuotaHandler) GetQuotaTree(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "0"))
+	if err != nil || depth < 0 {
+		qh.respondError(c, http.StatusBadRequest, "depth must be a non-negative integer", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Get quota tree
+	tree, err := qh.quotaService.GetQuotaTree(userInfo, quotaID, depth)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userInfo.UserID,
+			"quota_id": quotaID,
+			"depth":    depth,
+		}).Error("Failed to get quota tree")
+		qh.respondError(c, statusForServiceError(err), "Failed to get quota tree", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota tree retrieved successfully", tree)
+}
+
+// GetQuotaAncestors handles requests for a quota's chain of ancestors
+func (qh *QuotaHandler) GetQuotaAncestors(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Get quota ancestors
+	response, err := qh.quotaService.GetQuotaAncestors(userInfo, quotaID)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userInfo.UserID,
+			"quota_id": quotaID,
+		}).Error("Failed to get quota ancestors")
+		qh.respondError(c, statusForServiceError(err), "Failed to get quota ancestors", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota ancestors retrieved successfully", response)
+}
+
+// CheckRollups handles rollup consistency check requests
+func (qh *QuotaHandler) CheckRollups(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	// The body is optional when authenticating with a bearer token
+	var request models.QuotaRollupCheckRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if c.Query("repair") == "true" {
+		request.Repair = true
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Check rollups
+	response, err := qh.quotaService.CheckRollups(userInfo, quotaID, request.Repair)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userInfo.UserID,
+			"quota_id": quotaID,
+			"repair":   request.Repair,
+		}).Error("Failed to check quota rollups")
+		qh.respondError(c, statusForServiceError(err), "Failed to check quota rollups", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota rollups checked successfully", response)
+}
+
+// GetQuotaLimits handles quota limits retrieval requests
+func (qh *QuotaHandler) GetQuotaLimits(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Get limits
+	limits, err := qh.quotaService.GetQuotaLimits(userInfo, quotaID)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userInfo.UserID,
+			"quota_id": quotaID,
+		}).Error("Failed to get quota limits")
+		qh.respondError(c, statusForServiceError(err), "Failed to get quota limits", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota limits retrieved successfully", limits)
+}
+
+// UpdateQuotaLimits handles quota limits update requests
+func (qh *QuotaHandler) UpdateQuotaLimits(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	var request models.QuotaLimitsRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Update limits
+	limits, err := qh.quotaService.UpdateQuotaLimits(userInfo, quotaID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":            userInfo.UserID,
+			"quota_id":           quotaID,
+			"soft_limit_mb":      request.SoftLimitMB,
+			"warning_thresholds": request.WarningThresholds,
+		}).Error("Failed to update quota limits")
+		qh.respondError(c, statusForServiceError(err), "Failed to update quota limits", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Quota limits updated successfully", limits)
+}
+
 // GrantPermission handles quota permission grant requests
 func (qh *QuotaHandler) GrantPermission(c *gin.Context) {
 	quotaID := c.Param("id")
@@ -192,27 +474,78 @@ func (qh *QuotaHandler) GrantPermission(c *gin.Context) {
 		return
 	}
 
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(request.ServiceID, request.EncryptedData)
+	userInfo := qh.currentUser(c)
+
+	// Grant permission
+	err := qh.quotaService.GrantPermission(userInfo, quotaID, &request)
 	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"admin_user_id":  userInfo.UserID,
+			"target_user_id": request.TargetUserID,
+			"quota_id":       quotaID,
+			"permissions":    request.Permissions,
+		}).Error("Failed to grant quota permission")
+		qh.respondError(c, statusForServiceError(err), "Failed to grant permission", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Permission granted successfully", nil)
+}
+
+// RevokePermission handles quota permission revoke requests
+func (qh *QuotaHandler) RevokePermission(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
 		return
 	}
 
-	// Grant permission through auth service
-	err = qh.authClient.GrantPermission(userInfo, request.TargetUserID, quotaID, request.Permissions)
+	var request models.QuotaRevokePermissionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Revoke permission
+	err := qh.quotaService.RevokePermission(userInfo, quotaID, &request)
 	if err != nil {
 		qh.logger.WithError(err).WithFields(logrus.Fields{
 			"admin_user_id":  userInfo.UserID,
 			"target_user_id": request.TargetUserID,
 			"quota_id":       quotaID,
 			"permissions":    request.Permissions,
-		}).Error("Failed to grant quota permission")
-		qh.respondError(c, http.StatusInternalServerError, "Failed to grant permission", err)
+		}).Error("Failed to revoke quota permission")
+		qh.respondError(c, statusForServiceError(err), "Failed to revoke permission", err)
 		return
 	}
 
-	qh.respondSuccess(c, http.StatusOK, "Permission granted successfully", nil)
+	qh.respondSuccess(c, http.StatusOK, "Permission revoked successfully", nil)
+}
+
+// ListPermissions handles requests to list who holds access to a quota
+func (qh *QuotaHandler) ListPermissions(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// List permissions
+	response, err := qh.quotaService.ListPermissions(userInfo, quotaID)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":  userInfo.UserID,
+			"quota_id": quotaID,
+		}).Error("Failed to list quota permissions")
+		qh.respondError(c, statusForServiceError(err), "Failed to list permissions", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Permissions listed successfully", response)
 }
 
 // AllocateUsage handles usage allocation requests
@@ -228,28 +561,32 @@ func (qh *QuotaHandler) AllocateUsage(c *gin.Context) {
 		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
-
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(request.ServiceID, request.EncryptedData)
-	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
+	if err := bindIdempotencyKey(c, &request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid idempotency key", err)
 		return
 	}
 
+	userInfo := qh.currentUser(c)
+
 	// Allocate usage
-	err = qh.quotaService.AllocateUsage(userInfo, quotaID, &request)
+	result, err := qh.quotaService.AllocateUsage(userInfo, quotaID, &request)
 	if err != nil {
 		qh.logger.WithError(err).WithFields(logrus.Fields{
 			"user_id":     userInfo.UserID,
 			"quota_id":    quotaID,
 			"usage_mb":    request.UsageMB,
+			"dimensions":  request.Dimensions,
 			"resource_id": request.ResourceID,
 		}).Error("Failed to allocate usage")
-		qh.respondError(c, http.StatusInternalServerError, "Failed to allocate usage", err)
+		qh.respondError(c, statusForServiceError(err), "Failed to allocate usage", err)
 		return
 	}
 
-	qh.respondSuccess(c, http.StatusOK, "Usage allocated successfully", nil)
+	message := "Usage allocated successfully"
+	if result.Warning {
+		message = "Usage allocated successfully with warnings"
+	}
+	qh.respondSuccess(c, http.StatusOK, message, result)
 }
 
 // DeallocateUsage handles usage deallocation requests
@@ -265,53 +602,240 @@ func (qh *QuotaHandler) DeallocateUsage(c *gin.Context) {
 		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
-
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(request.ServiceID, request.EncryptedData)
-	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
+	if err := bindIdempotencyKey(c, &request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid idempotency key", err)
 		return
 	}
 
+	userInfo := qh.currentUser(c)
+
 	// Deallocate usage
-	err = qh.quotaService.DeallocateUsage(userInfo, quotaID, &request)
+	replayed, err := qh.quotaService.DeallocateUsage(userInfo, quotaID, &request)
 	if err != nil {
 		qh.logger.WithError(err).WithFields(logrus.Fields{
 			"user_id":     userInfo.UserID,
 			"quota_id":    quotaID,
 			"usage_mb":    request.UsageMB,
+			"dimensions":  request.Dimensions,
 			"resource_id": request.ResourceID,
 		}).Error("Failed to deallocate usage")
-		qh.respondError(c, http.StatusInternalServerError, "Failed to deallocate usage", err)
+		qh.respondError(c, statusForServiceError(err), "Failed to deallocate usage", err)
 		return
 	}
 
+	if replayed {
+		qh.respondSuccess(c, http.StatusOK, "Usage deallocated successfully", gin.H{"replayed": true})
+		return
+	}
 	qh.respondSuccess(c, http.StatusOK, "Usage deallocated successfully", nil)
 }
 
-// ListQuotas handles quota listing requests (placeholder for future implementation)
-func (qh *QuotaHandler) ListQuotas(c *gin.Context) {
-	// Get pagination parameters
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-	quotaType := c.Query("type")
+// ApplyUsageBatch handles batch usage requests across one or more quotas
+func (qh *QuotaHandler) ApplyUsageBatch(c *gin.Context) {
+	var request models.QuotaBatchUsageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
 
-	// Get encrypted data from query params
-	serviceID := c.Query("service_id")
-	encryptedData := c.Query("encrypted_data")
-	
-	if serviceID == "" || encryptedData == "" {
-		qh.respondError(c, http.StatusBadRequest, "service_id and encrypted_data are required", nil)
+	userInfo := qh.currentUser(c)
+
+	// Apply batch
+	result, err := qh.quotaService.ApplyUsageBatch(userInfo, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":    userInfo.UserID,
+			"mode":       request.Mode,
+			"operations": len(request.Operations),
+		}).Error("Failed to apply usage batch")
+		qh.respondError(c, statusForServiceError(err), "Failed to apply usage batch", err)
 		return
 	}
 
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(serviceID, encryptedData)
+	message := "Usage batch applied successfully"
+	if result.Failed > 0 {
+		message = fmt.Sprintf("Usage batch applied with %d failed operations", result.Failed)
+	}
+	qh.respondSuccess(c, http.StatusOK, message, result)
+}
+
+// TransferUsage handles usage transfer requests between resources or quotas
+func (qh *QuotaHandler) TransferUsage(c *gin.Context) {
+	var request models.QuotaUsageTransferRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Transfer usage
+	result, err := qh.quotaService.TransferUsage(userInfo, &request)
 	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":            userInfo.UserID,
+			"source_quota_id":    request.SourceQuotaID,
+			"source_resource_id": request.SourceResourceID,
+			"target_quota_id":    request.TargetQuotaID,
+			"target_resource_id": request.TargetResourceID,
+			"usage_mb":           request.UsageMB,
+		}).Error("Failed to transfer usage")
+		qh.respondError(c, statusForServiceError(err), "Failed to transfer usage", err)
 		return
 	}
 
+	message := "Usage transferred successfully"
+	if result.Target.Warning {
+		message = "Usage transferred successfully with warnings"
+	}
+	qh.respondSuccess(c, http.StatusOK, message, result)
+}
+
+// SetResourceUsage handles requests that set a resource's absolute usage
+func (qh *QuotaHandler) SetResourceUsage(c *gin.Context) {
+	quotaID := c.Param("id")
+	resourceID := c.Param("resource_id")
+	if quotaID == "" || resourceID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID and resource ID are required", nil)
+		return
+	}
+
+	var request models.QuotaSetUsageRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Set resource usage
+	result, err := qh.quotaService.SetResourceUsage(userInfo, quotaID, resourceID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":     userInfo.UserID,
+			"quota_id":    quotaID,
+			"resource_id": resourceID,
+			"usage_mb":    *request.UsageMB,
+		}).Error("Failed to set resource usage")
+		qh.respondError(c, statusForServiceError(err), "Failed to set resource usage", err)
+		return
+	}
+
+	message := "Resource usage set successfully"
+	if result.Warning {
+		message = "Resource usage set successfully with warnings"
+	}
+	qh.respondSuccess(c, http.StatusOK, message, result)
+}
+
+// CreateReservation handles usage reservation requests
+func (qh *QuotaHandler) CreateReservation(c *gin.Context) {
+	quotaID := c.Param("id")
+	if quotaID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID is required", nil)
+		return
+	}
+
+	var request models.QuotaReservationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Reserve usage
+	reservation, err := qh.quotaService.CreateReservation(userInfo, quotaID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":     userInfo.UserID,
+			"quota_id":    quotaID,
+			"usage_mb":    request.UsageMB,
+			"resource_id": request.ResourceID,
+			"ttl_seconds": request.TTLSeconds,
+		}).Error("Failed to create reservation")
+		qh.respondError(c, statusForServiceError(err), "Failed to create reservation", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusCreated, "Reservation created successfully", reservation)
+}
+
+// CommitReservation handles reservation commit requests
+func (qh *QuotaHandler) CommitReservation(c *gin.Context) {
+	quotaID := c.Param("id")
+	reservationID := c.Param("reservation_id")
+	if quotaID == "" || reservationID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID and reservation ID are required", nil)
+		return
+	}
+
+	// The body is optional when authenticating with a bearer token
+	var request models.QuotaReservationActionRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Commit reservation
+	reservation, err := qh.quotaService.CommitReservation(userInfo, quotaID, reservationID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":        userInfo.UserID,
+			"quota_id":       quotaID,
+			"reservation_id": reservationID,
+		}).Error("Failed to commit reservation")
+		qh.respondError(c, statusForServiceError(err), "Failed to commit reservation", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Reservation committed successfully", reservation)
+}
+
+// CancelReservation handles reservation cancel requests
+func (qh *QuotaHandler) CancelReservation(c *gin.Context) {
+	quotaID := c.Param("id")
+	reservationID := c.Param("reservation_id")
+	if quotaID == "" || reservationID == "" {
+		qh.respondError(c, http.StatusBadRequest, "Quota ID and reservation ID are required", nil)
+		return
+	}
+
+	// The body is optional when authenticating with a bearer token
+	var request models.QuotaReservationActionRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		qh.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userInfo := qh.currentUser(c)
+
+	// Cancel reservation
+	reservation, err := qh.quotaService.CancelReservation(userInfo, quotaID, reservationID, &request)
+	if err != nil {
+		qh.logger.WithError(err).WithFields(logrus.Fields{
+			"user_id":        userInfo.UserID,
+			"quota_id":       quotaID,
+			"reservation_id": reservationID,
+		}).Error("Failed to cancel reservation")
+		qh.respondError(c, statusForServiceError(err), "Failed to cancel reservation", err)
+		return
+	}
+
+	qh.respondSuccess(c, http.StatusOK, "Reservation cancelled successfully", reservation)
+}
+
+// ListQuotas handles quota listing requests (placeholder for future implementation)
+func (qh *QuotaHandler) ListQuotas(c *gin.Context) {
+	// Get pagination parameters
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	quotaType := c.Query("type")
+
+	userInfo := qh.currentUser(c)
+
 	// Get quotas from service
 	response, err := qh.quotaService.ListQuotas(userInfo, page, pageSize, quotaType)
 	if err != nil {
@@ -334,21 +858,7 @@ func (qh *QuotaHandler) ListRuntimeUsage(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
 	
-	// Get auth parameters
-	serviceID := c.Query("service_id")
-	encryptedData := c.Query("encrypted_data")
-	
-	if serviceID == "" || encryptedData == "" {
-		qh.respondError(c, http.StatusBadRequest, "service_id and encrypted_data are required", nil)
-		return
-	}
-
-	// Decrypt user info
-	userInfo, err := qh.decryptUserInfo(serviceID, encryptedData)
-	if err != nil {
-		qh.respondError(c, http.StatusUnauthorized, "Failed to decrypt user credentials", err)
-		return
-	}
+	userInfo := qh.currentUser(c)
 
 	// Get runtime usage from service
 	response, err := qh.quotaService.GetRuntimeUsage(userInfo, page, pageSize)
@@ -377,35 +887,63 @@ func (qh *QuotaHandler) HealthCheck(c *gin.Context) {
 
 // Helper methods
 
-func (qh *QuotaHandler) decryptUserInfo(serviceID, encryptedData string) (*auth.UserInfo, error) {
-	if serviceID != qh.authClient.ServiceID() {
-		return nil, fmt.Errorf("invalid service ID")
-	}
+// currentUser returns the caller resolved by middleware.Authenticate
+func (qh *QuotaHandler) currentUser(c *gin.Context) *auth.UserInfo {
+	userInfo, _ := middleware.CurrentUser(c)
+	return userInfo
+}
 
-	// For the quota service, we'll use a simple approach
-	// In a real implementation, you would decrypt the data using the same method as auth service
-	// For now, we'll create a mock user info based on the encrypted data
-	
-	// Decode base64 to simulate decryption
-	decoded, err := base64.StdEncoding.DecodeString(encryptedData)
-	if err != nil {
-		return nil, fmt.Errorf("invalid encrypted data format")
+// bindIdempotencyKey copies the Idempotency-Key header into a usage request.
+// The header and the idempotency_key field may both be set only if they agree.
+func bindIdempotencyKey(c *gin.Context, request *models.QuotaUsageRequest) error {
+	header := c.GetHeader(IdempotencyKeyHeader)
+	if header == "" {
+		return nil
 	}
-
-	// This is a simplified mock - in reality, you'd implement proper AES-GCM decryption
-	userInfo := &auth.UserInfo{
-		UserID:         "user_mock", // This should come from actual decryption
-		SessionID:      "session_mock",
-		OrganizationID: "org_default",
-		TeamIDs:        []string{"team_default"},
-		Timestamp:      1641945600000, // Mock timestamp
-		Nonce:          "mock-nonce",
+	if request.IdempotencyKey != "" && request.IdempotencyKey != header {
+		return fmt.Errorf("%s header and idempotency_key field differ", IdempotencyKeyHeader)
 	}
+	request.IdempotencyKey = header
+	return nil
+}
 
-	// Log that we're using mock data
-	qh.logger.Debug("Using mock user info for development", len(decoded))
-
-	return userInfo, nil
+// statusForServiceError maps quota service errors to HTTP status codes
+func statusForServiceError(err error) int {
+	switch {
+	case errors.Is(err, services.ErrQuotaSuspended):
+		return http.StatusLocked
+	case strings.Contains(err.Error(), "not found"):
+		return http.StatusNotFound
+	case strings.Contains(err.Error(), "insufficient permissions"):
+		return http.StatusForbidden
+	case strings.Contains(err.Error(), "overcommit_ratio must be"),
+		strings.Contains(err.Error(), "overcommit requires"),
+		strings.Contains(err.Error(), "invalid soft_limit_mb"),
+		strings.Contains(err.Error(), "invalid warning threshold"),
+		strings.Contains(err.Error(), "invalid dimension"),
+		strings.Contains(err.Error(), "usage_mb must not be negative"),
+		strings.Contains(err.Error(), "usage_mb or dimensions is required"),
+		strings.Contains(err.Error(), "invalid ttl_seconds"),
+		strings.Contains(err.Error(), "invalid idempotency_key"),
+		strings.Contains(err.Error(), "invalid batch mode"),
+		strings.Contains(err.Error(), "invalid transfer"):
+		return http.StatusBadRequest
+	case strings.Contains(err.Error(), "insufficient quota"),
+		strings.Contains(err.Error(), "cannot shrink"),
+		strings.Contains(err.Error(), "cannot release"),
+		strings.Contains(err.Error(), "cannot move"),
+		strings.Contains(err.Error(), "does not use rollup accounting"),
+		strings.Contains(err.Error(), "cannot suspend"),
+		strings.Contains(err.Error(), "cannot resume"),
+		strings.Contains(err.Error(), "cannot commit reservation"),
+		strings.Contains(err.Error(), "cannot cancel reservation"),
+		strings.Contains(err.Error(), "was already used with a different request"),
+		strings.Contains(err.Error(), "cannot deallocate"),
+		strings.Contains(err.Error(), "cannot transfer"):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func (qh *QuotaHandler) respondSuccess(c *gin.Context, status int, message string, data interface{}) {
@@ -437,6 +975,11 @@ func (qh *QuotaHandler) respondError(c *gin.Context, status int, message string,
 		logFields["error_detail"] = err.Error()
 	}
 
+	// Machine-readable code for errors clients are expected to handle
+	if errors.Is(err, services.ErrQuotaSuspended) {
+		response["code"] = "quota_suspended"
+	}
+
 	if status >= 500 {
 		qh.logger.WithFields(logFields).Error("Internal server error")
 	} else {
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminTokenHeader carries the operator token for /admin routes
+const AdminTokenHeader = "X-Admin-Token"
+
+// AdminAuth returns a gin middleware that only admits requests carrying the
+// configured admin token
+func AdminAuth(token string, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided := c.GetHeader(AdminTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			logger.WithFields(logrus.Fields{
+				"path":      c.Request.URL.Path,
+				"client_ip": c.ClientIP(),
+			}).Warn("Rejected admin request")
+
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "Invalid admin token",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
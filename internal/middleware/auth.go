@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// UserInfoKey is the gin context key holding the authenticated *auth.UserInfo
+const UserInfoKey = "user_info"
+
+// bearerPrefix prefixes a JWT in the Authorization header
+const bearerPrefix = "Bearer "
+
+// redactedValue replaces credentials in logged query strings
+const redactedValue = "REDACTED"
+
+// Authenticate returns a gin middleware that resolves the caller once per
+// request and stores it under UserInfoKey. It accepts, in order:
+//   - Authorization: Bearer <JWT> (when verifier is non-nil)
+//   - service_id/encrypted_data query parameters
+//   - service_id/encrypted_data fields in a JSON body
+func Authenticate(authClient *auth.AuthClient, verifier *auth.JWTVerifier, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userInfo, err := resolveUserInfo(c, authClient, verifier)
+		if err != nil {
+			logger.WithError(err).WithFields(logrus.Fields{
+				"path":      c.Request.URL.Path,
+				"client_ip": c.ClientIP(),
+			}).Warn("Rejected unauthenticated request")
+
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   authErrorMessage(err),
+			})
+			return
+		}
+
+		c.Set(UserInfoKey, userInfo)
+		c.Next()
+	}
+}
+
+// CurrentUser returns the user resolved by Authenticate
+func CurrentUser(c *gin.Context) (*auth.UserInfo, bool) {
+	value, exists := c.Get(UserInfoKey)
+	if !exists {
+		return nil, false
+	}
+	userInfo, ok := value.(*auth.UserInfo)
+	return userInfo, ok && userInfo != nil
+}
+
+// errNoCredentials is returned when a request carries no credentials at all
+var errNoCredentials = errors.New("no credentials provided")
+
+func resolveUserInfo(c *gin.Context, authClient *auth.AuthClient, verifier *auth.JWTVerifier) (*auth.UserInfo, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if !strings.HasPrefix(header, bearerPrefix) {
+			return nil, fmt.Errorf("%w: unsupported authorization scheme", auth.ErrInvalidToken)
+		}
+		if verifier == nil {
+			return nil, fmt.Errorf("%w: bearer authentication not configured", auth.ErrInvalidToken)
+		}
+		return verifier.Verify(strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix)))
+	}
+
+	serviceID := c.Query("service_id")
+	encryptedData := c.Query("encrypted_data")
+
+	if encryptedData == "" && c.Request.Body != nil && c.ContentType() == "application/json" {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		// Restore the body for the handler's own binding
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var credentials struct {
+			ServiceID     string `json:"service_id"`
+			EncryptedData string `json:"encrypted_data"`
+		}
+		if len(body) > 0 && json.Unmarshal(body, &credentials) == nil {
+			serviceID = credentials.ServiceID
+			encryptedData = credentials.EncryptedData
+		}
+	}
+
+	if serviceID == "" || encryptedData == "" {
+		return nil, errNoCredentials
+	}
+
+	if serviceID != authClient.ServiceID() {
+		return nil, fmt.Errorf("invalid service ID")
+	}
+
+	return authClient.DecryptUserInfo(encryptedData)
+}
+
+func authErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errNoCredentials):
+		return "Authentication required"
+	case errors.Is(err, auth.ErrTokenExpired):
+		return "Bearer token expired"
+	case errors.Is(err, auth.ErrInvalidToken):
+		return "Invalid bearer token"
+	case errors.Is(err, auth.ErrPayloadExpired):
+		return "User credentials expired"
+	case errors.Is(err, auth.ErrPayloadReplayed):
+		return "User credentials already used"
+	default:
+		return "Failed to decrypt user credentials"
+	}
+}
+
+// RedactQuery masks credentials in a raw query string before it is logged
+func RedactQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redactedValue
+	}
+	if _, exists := values["encrypted_data"]; !exists {
+		return rawQuery
+	}
+	values.Set("encrypted_data", redactedValue)
+	return values.Encode()
+}
@@ -32,6 +32,7 @@ func DefaultCORSConfig() CORSConfig {
 			"X-CSRF-Token",
 			"X-Request-ID",
 			"X-Requested-With",
+			"Idempotency-Key",
 		},
 		ExposeHeaders:    []string{"Content-Length", "Content-Type", "X-Request-ID"},
 		AllowCredentials: true,
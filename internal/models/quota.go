@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"math"
 	"time"
 )
 
@@ -19,6 +20,25 @@ type Quota struct {
 	UsedMB        int64 `json:"used_mb" db:"used_mb"`
 	AllocatedMB   int64 `json:"allocated_mb" db:"allocated_mb"`
 	AvailableMB   int64 `json:"available_mb" db:"available_mb"` // computed: total - used - allocated
+
+	// Rollup accounting (usage_mode is inherited from the root quota)
+	UsageMode     string `json:"usage_mode" db:"usage_mode"`           // direct | rollup
+	SubtreeUsedMB int64  `json:"subtree_used_mb" db:"subtree_used_mb"` // rollup mode: used_mb of this quota and all descendants
+
+	// Overcommit: children may be allocated up to total_mb * overcommit_ratio
+	OvercommitRatio     float64 `json:"overcommit_ratio" db:"overcommit_ratio"`
+	AllocatableMB       int64   `json:"allocatable_mb" db:"-"`                  // computed: total * overcommit_ratio - used - allocated
+	PhysicalAvailableMB *int64  `json:"physical_available_mb,omitempty" db:"-"` // computed in rollup mode: total - subtree_used
+
+	// Reservations: capacity held by active reservations, counted in used_mb
+	ReservedMB int64 `json:"reserved_mb" db:"reserved_mb"`
+
+	// Warning limits: crossing them succeeds but is reported
+	SoftLimitMB       *int64  `json:"soft_limit_mb" db:"soft_limit_mb"`
+	WarningThresholds []int64 `json:"warning_thresholds" db:"warning_thresholds"` // percent of total_mb, ascending
+
+	// Additional resource dimensions (stored in quota_dimensions)
+	Dimensions []QuotaDimension `json:"dimensions,omitempty" db:"-"`
 	
 	// Hierarchy
 	ParentQuotaID *string `json:"parent_quota_id" db:"parent_quota_id"`
@@ -35,6 +55,113 @@ type Quota struct {
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 	DeletedAt *time.Time `json:"deleted_at" db:"deleted_at"`
+
+	// Access describes how the caller's permission was resolved (not stored)
+	Access *PermissionDecision `json:"access,omitempty" db:"-"`
+}
+
+// ComputeCapacity fills in the computed capacity fields
+func (q *Quota) ComputeCapacity() {
+	q.AvailableMB = q.TotalMB - q.UsedMB - q.AllocatedMB
+	q.AllocatableMB = OvercommitLimitMB(q.TotalMB, q.OvercommitRatio) - q.UsedMB - q.AllocatedMB
+	q.PhysicalAvailableMB = nil
+	if q.UsageMode == UsageModeRollup {
+		physical := q.TotalMB - q.SubtreeUsedMB
+		q.PhysicalAvailableMB = &physical
+	}
+}
+
+// OvercommitLimitMB returns how much a quota of totalMB may hand out in total
+func OvercommitLimitMB(totalMB int64, ratio float64) int64 {
+	if ratio < 1 {
+		ratio = 1
+	}
+	return int64(math.Floor(float64(totalMB) * ratio))
+}
+
+// QuotaDimension is a named resource metered by a quota besides storage MB.
+// It follows the same hierarchy accounting as the MB columns.
+type QuotaDimension struct {
+	Name        string `json:"name" db:"dimension"`
+	Unit        string `json:"unit" db:"unit"`
+	Total       int64  `json:"total" db:"total"`
+	Used        int64  `json:"used" db:"used"`
+	Allocated   int64  `json:"allocated" db:"allocated"`
+	Available   int64  `json:"available" db:"-"`               // computed: total - used - allocated
+	SubtreeUsed int64  `json:"subtree_used" db:"subtree_used"` // rollup mode only
+}
+
+// QuotaDimensionSpec defines a dimension on a root quota
+type QuotaDimensionSpec struct {
+	Name  string `json:"name" binding:"required"`
+	Unit  string `json:"unit" binding:"required"`
+	Total int64  `json:"total" binding:"min=0"`
+}
+
+// PermissionDecision describes how a permission check on a quota was resolved
+// along its path
+type PermissionDecision struct {
+	Allowed    bool              `json:"allowed"`
+	Inherited  bool              `json:"inherited"`             // granted by an ancestor quota
+	GrantedVia map[string]string `json:"granted_via,omitempty"` // permission -> quota that supplied the grant
+	DeniedBy   map[string]string `json:"denied_by,omitempty"`   // permission -> quota with an explicit deny
+}
+
+// QuotaTreeNode is a quota with its descendants and subtree rollups
+type QuotaTreeNode struct {
+	Quota
+	UtilizationPercent float64          `json:"utilization_percent"` // subtree_used_mb / total_mb (always computed live)
+	Truncated          bool             `json:"truncated,omitempty"` // children exist below the requested depth
+	Children           []*QuotaTreeNode `json:"children"`
+}
+
+// QuotaAncestor is one quota on the chain from the root to a quota
+type QuotaAncestor struct {
+	QuotaID     string `json:"quota_id"`
+	Name        string `json:"name,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Level       int    `json:"level"`
+	Status      string `json:"status,omitempty"`
+	TotalMB     int64  `json:"total_mb"`
+	UsedMB      int64  `json:"used_mb"`
+	AllocatedMB int64  `json:"allocated_mb"`
+	AvailableMB int64  `json:"available_mb"`
+	HeadroomMB  int64  `json:"headroom_mb"` // room left for the path below this quota
+
+	UsageMode     string `json:"usage_mode,omitempty"`
+	SubtreeUsedMB int64  `json:"subtree_used_mb,omitempty"` // rollup mode only
+
+	Restricted bool `json:"restricted,omitempty"` // caller cannot read this quota; capacity is zeroed
+}
+
+// QuotaAncestorsResponse represents a quota's chain of ancestors, root first
+type QuotaAncestorsResponse struct {
+	QuotaID              string          `json:"quota_id"`
+	Ancestors            []QuotaAncestor `json:"ancestors"`
+	EffectiveAvailableMB int64           `json:"effective_available_mb"` // minimum headroom_mb along the chain
+	BottleneckQuotaID    string          `json:"bottleneck_quota_id"`    // quota that sets the effective figure
+}
+
+// QuotaRollupCheckRequest represents a request to verify rollup counters
+type QuotaRollupCheckRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
+	Repair        bool   `json:"repair"` // overwrite drifted counters with recomputed values
+}
+
+// QuotaRollupDrift is a quota whose stored subtree_used_mb is wrong
+type QuotaRollupDrift struct {
+	QuotaID    string `json:"quota_id"`
+	StoredMB   int64  `json:"stored_mb"`
+	ComputedMB int64  `json:"computed_mb"`
+}
+
+// QuotaRollupCheckResponse reports the result of a rollup consistency check
+type QuotaRollupCheckResponse struct {
+	QuotaID      string             `json:"quota_id"`
+	CheckedCount int                `json:"checked_count"`
+	Drift        []QuotaRollupDrift `json:"drift"`
+	Repaired     bool               `json:"repaired"`
 }
 
 // QuotaUsage represents quota usage records
@@ -97,48 +224,345 @@ const (
 	QuotaStatusSuspended = "suspended"
 	QuotaStatusDeleted   = "deleted"
 	
+	UsageModeDirect = "direct" // usage counts against the target quota only
+	UsageModeRollup = "rollup" // usage also counts against every ancestor's subtree_used_mb
+
 	OperationAllocate   = "allocate"
 	OperationDeallocate = "deallocate"
 )
 
 // QuotaCreateRequest represents a request to create a quota
 type QuotaCreateRequest struct {
-	ServiceID       string   `json:"service_id" binding:"required"`
-	EncryptedData   string   `json:"encrypted_data" binding:"required"`
+	ServiceID       string  `json:"service_id"`
+	EncryptedData   string  `json:"encrypted_data"`
+	Name            string  `json:"name" binding:"required"`
+	Description     string  `json:"description"`
+	Type            string  `json:"type" binding:"required"`
+	TotalMB         int64   `json:"total_mb" binding:"required,min=1"`
+	OrganizationID  string  `json:"organization_id,omitempty"`
+	TeamID          *string `json:"team_id,omitempty"`
+	UsageMode       string  `json:"usage_mode,omitempty"`       // direct (default) | rollup
+	OvercommitRatio float64 `json:"overcommit_ratio,omitempty"` // >= 1, requires rollup mode
+
+	Dimensions []QuotaDimensionSpec `json:"dimensions,omitempty"` // resources besides storage MB
+}
+
+// QuotaAllocateRequest represents a request to allocate a sub-quota
+type QuotaAllocateRequest struct {
+	ServiceID       string   `json:"service_id"`
+	EncryptedData   string   `json:"encrypted_data"`
 	Name            string   `json:"name" binding:"required"`
 	Description     string   `json:"description"`
+	AllocateMB      int64    `json:"allocate_mb" binding:"required,min=1"`
 	Type            string   `json:"type" binding:"required"`
-	TotalMB         int64    `json:"total_mb" binding:"required,min=1"`
-	OrganizationID  string   `json:"organization_id,omitempty"`
-	TeamID          *string  `json:"team_id,omitempty"`
+	TargetID        string   `json:"target_id"`                  // organization_id or team_id
+	AdminUserIDs    []string `json:"admin_user_ids"`             // users to grant admin permission
+	OvercommitRatio float64  `json:"overcommit_ratio,omitempty"` // >= 1, requires rollup mode
+
+	Dimensions map[string]int64 `json:"dimensions,omitempty"` // amount of each parent dimension to hand out
 }
 
-// QuotaAllocateRequest represents a request to allocate a sub-quota
-type QuotaAllocateRequest struct {
-	ServiceID     string   `json:"service_id" binding:"required"`
-	EncryptedData string   `json:"encrypted_data" binding:"required"`
-	Name          string   `json:"name" binding:"required"`
-	Description   string   `json:"description"`
-	AllocateMB    int64    `json:"allocate_mb" binding:"required,min=1"`
-	Type          string   `json:"type" binding:"required"`
-	TargetID      string   `json:"target_id"`          // organization_id or team_id
-	AdminUserIDs  []string `json:"admin_user_ids"`     // users to grant admin permission
+// QuotaReleaseRequest represents a request to release a quota
+type QuotaReleaseRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
+	Recursive     bool   `json:"recursive"` // release the whole subtree, force-deallocating usage
+	DryRun        bool   `json:"dry_run"`   // with recursive: only return the plan
+	Reason        string `json:"reason"`
+}
+
+// QuotaReleasePlan describes a recursive release, executed or not
+type QuotaReleasePlan struct {
+	QuotaID       string             `json:"quota_id"`
+	ParentQuotaID *string            `json:"parent_quota_id"`
+	DryRun        bool               `json:"dry_run"`
+	ReturnedMB    int64              `json:"returned_mb"`     // capacity returned to the parent quota
+	ForcedUsageMB int64              `json:"forced_usage_mb"` // usage force-deallocated across the subtree
+	Steps         []QuotaReleaseStep `json:"steps"`           // children before parents
+}
+
+// QuotaReleaseStep is a single quota released by a recursive release
+type QuotaReleaseStep struct {
+	QuotaID       string               `json:"quota_id"`
+	ParentQuotaID *string              `json:"parent_quota_id"`
+	Level         int                  `json:"level"`
+	TotalMB       int64                `json:"total_mb"`
+	ForcedUsage   []QuotaResourceUsage `json:"forced_usage"`
+	Dimensions    []QuotaDimension     `json:"dimensions,omitempty"` // totals returned, used force-deallocated
+}
+
+// QuotaResourceUsage is the net usage a resource holds on a quota
+type QuotaResourceUsage struct {
+	ResourceID string `json:"resource_id"`
+	UsageMB    int64  `json:"usage_mb"`
+}
+
+// QuotaResizeRequest represents a request to change a quota's total capacity
+type QuotaResizeRequest struct {
+	ServiceID       string   `json:"service_id"`
+	EncryptedData   string   `json:"encrypted_data"`
+	TotalMB         int64    `json:"total_mb" binding:"omitempty,min=1"` // new capacity; 0 keeps the current one
+	OvercommitRatio *float64 `json:"overcommit_ratio,omitempty"`         // new overcommit ratio, >= 1
+	Reason          string   `json:"reason"`
+
+	Dimensions map[string]int64 `json:"dimensions,omitempty"` // new totals for existing dimensions
+}
+
+// QuotaStatusRequest represents a request to suspend or resume a quota
+type QuotaStatusRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
+	Cascade       bool   `json:"cascade"` // also apply to every descendant quota
+	Reason        string `json:"reason"`
+}
+
+// QuotaStatusResponse reports the quotas changed by a suspend or resume
+type QuotaStatusResponse struct {
+	QuotaID          string   `json:"quota_id"`
+	Status           string   `json:"status"`
+	Cascade          bool     `json:"cascade"`
+	AffectedQuotaIDs []string `json:"affected_quota_ids"`
+}
+
+// QuotaMoveRequest represents a request to re-parent a quota and its subtree
+type QuotaMoveRequest struct {
+	ServiceID        string `json:"service_id"`
+	EncryptedData    string `json:"encrypted_data"`
+	NewParentQuotaID string `json:"new_parent_quota_id" binding:"required"`
+	Reason           string `json:"reason"`
 }
 
 // QuotaGrantPermissionRequest represents a request to grant quota permissions
 type QuotaGrantPermissionRequest struct {
-	ServiceID     string   `json:"service_id" binding:"required"`
-	EncryptedData string   `json:"encrypted_data" binding:"required"`
+	ServiceID     string   `json:"service_id"`
+	EncryptedData string   `json:"encrypted_data"`
 	TargetUserID  string   `json:"target_user_id" binding:"required"`
 	Permissions   []string `json:"permissions" binding:"required"`
+	Effect        string   `json:"effect"`     // allow (default) | deny
+	ExpiresAt     *int64   `json:"expires_at"` // optional, Unix milliseconds
+}
+
+// QuotaRevokePermissionRequest represents a request to revoke quota permissions
+type QuotaRevokePermissionRequest struct {
+	ServiceID     string   `json:"service_id"`
+	EncryptedData string   `json:"encrypted_data"`
+	TargetUserID  string   `json:"target_user_id" binding:"required"`
+	Permissions   []string `json:"permissions" binding:"required"`
+}
+
+// QuotaPermissionEntry represents a grant (or explicit deny) held by a user on a quota
+type QuotaPermissionEntry struct {
+	UserID     string `json:"user_id"`
+	Permission string `json:"permission"`
+	Effect     string `json:"effect"`
+	GrantedBy  string `json:"granted_by,omitempty"`
+	ExpiresAt  *int64 `json:"expires_at,omitempty"` // Unix milliseconds
+}
+
+// QuotaPermissionListResponse represents the grants held on a quota
+type QuotaPermissionListResponse struct {
+	QuotaID     string                 `json:"quota_id"`
+	Permissions []QuotaPermissionEntry `json:"permissions"`
 }
 
 // QuotaUsageRequest represents a request to allocate/deallocate usage
 type QuotaUsageRequest struct {
-	ServiceID     string `json:"service_id" binding:"required"`
-	EncryptedData string `json:"encrypted_data" binding:"required"`
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
+	ResourceID    string `json:"resource_id" binding:"required"`
+	UsageMB       int64  `json:"usage_mb" binding:"min=0"`
+	Reason        string `json:"reason"`
+
+	// Dimensions maps dimension name to amount. The call only succeeds if
+	// usage_mb and every dimension fit.
+	Dimensions map[string]int64 `json:"dimensions,omitempty"`
+
+	// IdempotencyKey makes retries safe: a repeated request with the same key
+	// returns the original result. The Idempotency-Key header takes the same value.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// QuotaUsageResponse is returned by a successful usage allocation. Warnings
+// lists the soft limit and thresholds crossed by this call.
+type QuotaUsageResponse struct {
+	QuotaID     string              `json:"quota_id"`
+	UsedMB      int64               `json:"used_mb"`
+	AvailableMB int64               `json:"available_mb"`
+	Warning     bool                `json:"warning"`
+	Warnings    []QuotaUsageWarning `json:"warnings"`
+	Dimensions  []QuotaDimension    `json:"dimensions,omitempty"` // dimensions touched by the call
+	Replayed    bool                `json:"replayed,omitempty"`   // original result of an earlier request with the same idempotency key
+}
+
+// QuotaBatchUsageRequest applies several usage operations in one transaction
+type QuotaBatchUsageRequest struct {
+	ServiceID     string                     `json:"service_id"`
+	EncryptedData string                     `json:"encrypted_data"`
+	Mode          string                     `json:"mode"` // atomic (default) | best_effort
+	Operations    []QuotaBatchUsageOperation `json:"operations" binding:"required,min=1,max=100,dive"`
+}
+
+// QuotaBatchUsageOperation is a single allocate or deallocate in a batch
+type QuotaBatchUsageOperation struct {
+	QuotaID        string           `json:"quota_id" binding:"required"`
+	Operation      string           `json:"operation" binding:"required,oneof=allocate deallocate"`
+	ResourceID     string           `json:"resource_id" binding:"required"`
+	UsageMB        int64            `json:"usage_mb" binding:"min=0"`
+	Dimensions     map[string]int64 `json:"dimensions,omitempty"`
+	Reason         string           `json:"reason"`
+	IdempotencyKey string           `json:"idempotency_key,omitempty"`
+}
+
+// UsageRequest returns the operation as a single-quota usage request
+func (op *QuotaBatchUsageOperation) UsageRequest() *QuotaUsageRequest {
+	return &QuotaUsageRequest{
+		ResourceID:     op.ResourceID,
+		UsageMB:        op.UsageMB,
+		Reason:         op.Reason,
+		Dimensions:     op.Dimensions,
+		IdempotencyKey: op.IdempotencyKey,
+	}
+}
+
+// QuotaBatchUsageResponse reports the outcome of every operation in a batch
+type QuotaBatchUsageResponse struct {
+	Mode      string                  `json:"mode"`
+	Succeeded int                     `json:"succeeded"`
+	Failed    int                     `json:"failed"`
+	Results   []QuotaBatchUsageResult `json:"results"`
+}
+
+// QuotaBatchUsageResult is the outcome of one batch operation
+type QuotaBatchUsageResult struct {
+	Index     int                 `json:"index"`
+	QuotaID   string              `json:"quota_id"`
+	Operation string              `json:"operation"`
+	Success   bool                `json:"success"`
+	Error     string              `json:"error,omitempty"`
+	Usage     *QuotaUsageResponse `json:"usage,omitempty"`
+}
+
+// Batch usage modes
+const (
+	BatchModeAtomic     = "atomic"      // any failure rolls back the whole batch
+	BatchModeBestEffort = "best_effort" // failed operations are skipped
+)
+
+// QuotaUsageTransferRequest moves usage from one (quota, resource) pair to another
+type QuotaUsageTransferRequest struct {
+	ServiceID        string `json:"service_id"`
+	EncryptedData    string `json:"encrypted_data"`
+	SourceQuotaID    string `json:"source_quota_id" binding:"required"`
+	SourceResourceID string `json:"source_resource_id" binding:"required"`
+	TargetQuotaID    string `json:"target_quota_id" binding:"required"`
+	TargetResourceID string `json:"target_resource_id" binding:"required"`
+	UsageMB          int64  `json:"usage_mb" binding:"required,min=1"`
+	Reason           string `json:"reason"`
+}
+
+// QuotaUsageTransferResponse is returned by a successful usage transfer. Both
+// quota_usage rows written by the transfer carry its transfer_id.
+type QuotaUsageTransferResponse struct {
+	TransferID       string             `json:"transfer_id"`
+	SourceResourceID string             `json:"source_resource_id"`
+	TargetResourceID string             `json:"target_resource_id"`
+	UsageMB          int64              `json:"usage_mb"`
+	Source           QuotaUsageResponse `json:"source"`
+	Target           QuotaUsageResponse `json:"target"`
+}
+
+// QuotaSetUsageRequest sets the absolute usage of one resource on a quota
+type QuotaSetUsageRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
+	UsageMB       *int64 `json:"usage_mb" binding:"required,min=0"`
+	Reason        string `json:"reason"`
+}
+
+// QuotaSetUsageResponse is returned by a successful set-usage call. The
+// embedded fields describe the quota after the change.
+type QuotaSetUsageResponse struct {
+	QuotaUsageResponse
+	ResourceID string `json:"resource_id"`
+	PreviousMB int64  `json:"previous_mb"` // resource usage before the call
+	UsageMB    int64  `json:"usage_mb"`    // resource usage after the call
+	DeltaMB    int64  `json:"delta_mb"`    // allocated (> 0) or deallocated (< 0) by the call
+}
+
+// QuotaUsageWarning describes a single warning limit crossed by a usage call
+type QuotaUsageWarning struct {
+	Type             string `json:"type"`                        // threshold | soft_limit
+	ThresholdPercent int64  `json:"threshold_percent,omitempty"` // threshold warnings only
+	LimitMB          int64  `json:"limit_mb"`
+	BeforeMB         int64  `json:"before_mb"`
+	AfterMB          int64  `json:"after_mb"`
+}
+
+// Usage warning types
+const (
+	UsageWarningThreshold = "threshold"
+	UsageWarningSoftLimit = "soft_limit"
+)
+
+// QuotaLimitsRequest represents a request to replace a quota's warning limits
+type QuotaLimitsRequest struct {
+	ServiceID         string  `json:"service_id"`
+	EncryptedData     string  `json:"encrypted_data"`
+	SoftLimitMB       *int64  `json:"soft_limit_mb"`      // null or omitted clears it
+	WarningThresholds []int64 `json:"warning_thresholds"` // percent of total_mb, 1-100
+	Reason            string  `json:"reason"`
+}
+
+// QuotaLimits describes a quota's hard and warning limits
+type QuotaLimits struct {
+	QuotaID           string  `json:"quota_id"`
+	TotalMB           int64   `json:"total_mb"`
+	SoftLimitMB       *int64  `json:"soft_limit_mb"`
+	WarningThresholds []int64 `json:"warning_thresholds"`
+	ConsumedMB        int64   `json:"consumed_mb"` // used + allocated
+}
+
+// QuotaReservation holds usage capacity on a quota until it is committed into
+// quota_usage, cancelled, or expires
+type QuotaReservation struct {
+	ID         string     `json:"id" db:"id"`
+	QuotaID    string     `json:"quota_id" db:"quota_id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	ResourceID string     `json:"resource_id" db:"resource_id"`
+	UsageMB    int64      `json:"usage_mb" db:"usage_mb"`
+	Status     string     `json:"status" db:"status"` // active | committed | cancelled | expired
+	Reason     string     `json:"reason" db:"reason"`
+	UsageID    *string    `json:"usage_id" db:"usage_id"` // quota_usage row written on commit
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at" db:"resolved_at"`
+
+	// Warnings lists the soft limit and thresholds crossed when the hold was created
+	Warnings []QuotaUsageWarning `json:"warnings,omitempty" db:"-"`
+}
+
+// Reservation statuses
+const (
+	ReservationStatusActive    = "active"
+	ReservationStatusCommitted = "committed"
+	ReservationStatusCancelled = "cancelled"
+	ReservationStatusExpired   = "expired"
+)
+
+// QuotaReservationRequest represents a request to reserve usage capacity
+type QuotaReservationRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
 	ResourceID    string `json:"resource_id" binding:"required"`
 	UsageMB       int64  `json:"usage_mb" binding:"required,min=1"`
+	TTLSeconds    int    `json:"ttl_seconds" binding:"min=0"` // 0 uses the server default
+	Reason        string `json:"reason"`
+}
+
+// QuotaReservationActionRequest represents a request to commit or cancel a reservation
+type QuotaReservationActionRequest struct {
+	ServiceID     string `json:"service_id"`
+	EncryptedData string `json:"encrypted_data"`
 	Reason        string `json:"reason"`
 }
 
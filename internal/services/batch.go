@@ -0,0 +1,214 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// batchUsageItem is a batch operation that passed validation and authorization
+type batchUsageItem struct {
+	index       int
+	op          *models.QuotaBatchUsageOperation
+	request     *models.QuotaUsageRequest
+	requestHash string
+}
+
+// ApplyUsageBatch applies a list of allocate/deallocate operations, possibly
+// across several quotas, in a single transaction. All quotas involved (and
+// their ancestors) are locked up front, shallowest first, so concurrent
+// batches cannot deadlock. In atomic mode the first failure rolls back the
+// whole batch; in best_effort mode each operation runs under a savepoint and
+// failed operations are reported in the results.
+func (qs *QuotaService) ApplyUsageBatch(userInfo *auth.UserInfo, request *models.QuotaBatchUsageRequest) (*models.QuotaBatchUsageResponse, error) {
+	mode := request.Mode
+	if mode == "" {
+		mode = models.BatchModeAtomic
+	}
+	if mode != models.BatchModeAtomic && mode != models.BatchModeBestEffort {
+		return nil, fmt.Errorf("invalid batch mode %q: must be %s or %s", mode, models.BatchModeAtomic, models.BatchModeBestEffort)
+	}
+
+	response := &models.QuotaBatchUsageResponse{
+		Mode:    mode,
+		Results: make([]models.QuotaBatchUsageResult, len(request.Operations)),
+	}
+	fail := func(index int, err error) {
+		response.Results[index].Success = false
+		response.Results[index].Error = err.Error()
+		response.Failed++
+	}
+
+	// 1. Validate and authorize every operation before taking any lock
+	var items []batchUsageItem
+	authorized := make(map[string]error)
+	for i := range request.Operations {
+		op := &request.Operations[i]
+		response.Results[i] = models.QuotaBatchUsageResult{Index: i, QuotaID: op.QuotaID, Operation: op.Operation}
+
+		item, err := qs.prepareBatchUsageItem(userInfo, i, op, authorized)
+		if err != nil {
+			if mode == models.BatchModeAtomic {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			fail(i, err)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	// 2. Apply the operations in request order
+	capacityErrs := make(map[string]*physicalCapacityError)
+	noteCapacityErr := func(quotaID string, err error) {
+		var capacityErr *physicalCapacityError
+		if errors.As(err, &capacityErr) {
+			capacityErrs[quotaID] = capacityErr
+		}
+	}
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		quotaIDs := make([]string, 0, len(items))
+		for _, item := range items {
+			quotaIDs = append(quotaIDs, item.op.QuotaID)
+		}
+		if err := qs.lockUsageBatchTx(tx, quotaIDs); err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if mode == models.BatchModeAtomic {
+				usage, err := qs.applyBatchUsageItemTx(tx, userInfo, item)
+				if err != nil {
+					noteCapacityErr(item.op.QuotaID, err)
+					return fmt.Errorf("operation %d: %w", item.index, err)
+				}
+				response.Results[item.index].Success = true
+				response.Results[item.index].Usage = usage
+				continue
+			}
+
+			if _, err := tx.Exec(`SAVEPOINT batch_item`); err != nil {
+				return fmt.Errorf("failed to create savepoint: %w", err)
+			}
+			usage, err := qs.applyBatchUsageItemTx(tx, userInfo, item)
+			if err != nil {
+				if _, rbErr := tx.Exec(`ROLLBACK TO SAVEPOINT batch_item`); rbErr != nil {
+					return fmt.Errorf("failed to roll back operation %d: %w", item.index, rbErr)
+				}
+				noteCapacityErr(item.op.QuotaID, err)
+				fail(item.index, err)
+				continue
+			}
+			if _, err := tx.Exec(`RELEASE SAVEPOINT batch_item`); err != nil {
+				return fmt.Errorf("failed to release savepoint: %w", err)
+			}
+			response.Results[item.index].Success = true
+			response.Results[item.index].Usage = usage
+		}
+
+		return nil
+	})
+
+	for quotaID, capacityErr := range capacityErrs {
+		qs.reportOvercommitExhausted(userInfo, quotaID, capacityErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response.Succeeded = len(request.Operations) - response.Failed
+	for i, op := range request.Operations {
+		if usage := response.Results[i].Usage; usage != nil {
+			qs.logUsageResult(op.QuotaID, op.UsageRequest(), usage)
+		}
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"user_id":   userInfo.UserID,
+		"mode":      mode,
+		"succeeded": response.Succeeded,
+		"failed":    response.Failed,
+	}).Info("Usage batch applied")
+
+	return response, nil
+}
+
+// prepareBatchUsageItem validates a batch operation and checks the caller's
+// permission on its quota. Decisions are cached per quota and operation.
+func (qs *QuotaService) prepareBatchUsageItem(userInfo *auth.UserInfo, index int, op *models.QuotaBatchUsageOperation, authorized map[string]error) (batchUsageItem, error) {
+	item := batchUsageItem{index: index, op: op, request: op.UsageRequest()}
+	if err := validateUsageRequest(item.request); err != nil {
+		return item, err
+	}
+	requestHash, err := qs.idempotencyHash(userInfo, op.Operation, item.request)
+	if err != nil {
+		return item, err
+	}
+	item.requestHash = requestHash
+
+	action := "use quota"
+	if op.Operation == models.OperationDeallocate {
+		action = "deallocate quota usage"
+	}
+	cacheKey := op.QuotaID + "|" + op.Operation
+	authErr, seen := authorized[cacheKey]
+	if !seen {
+		_, authErr = qs.authorize(userInfo, op.QuotaID, []string{auth.QuotaPermissionRead}, action)
+		authorized[cacheKey] = authErr
+	}
+	return item, authErr
+}
+
+// applyBatchUsageItemTx applies one batch operation within tx
+func (qs *QuotaService) applyBatchUsageItemTx(tx *sql.Tx, userInfo *auth.UserInfo, item batchUsageItem) (*models.QuotaUsageResponse, error) {
+	if item.op.Operation == models.OperationDeallocate {
+		return qs.deallocateUsageTx(tx, userInfo, item.op.QuotaID, item.request, item.requestHash)
+	}
+	return qs.allocateUsageTx(tx, userInfo, item.op.QuotaID, item.request, item.requestHash)
+}
+
+// lockUsageBatchTx locks the given quotas in one statement, shallowest first,
+// together with the ancestors of those in rollup mode, matching what
+// lockQuotaForUsageTx locks for each of them. lockQuotaForUsageTx later
+// re-checks each quota's path, so a concurrent move is reported rather than
+// missed.
+func (qs *QuotaService) lockUsageBatchTx(tx *sql.Tx, quotaIDs []string) error {
+	if len(quotaIDs) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(`SELECT id, path, usage_mode FROM quotas WHERE id = ANY($1) AND status != $2`,
+		pq.Array(quotaIDs), models.QuotaStatusDeleted)
+	if err != nil {
+		return fmt.Errorf("failed to get quota paths: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	var lockIDs []string
+	for rows.Next() {
+		var quotaID, path, usageMode string
+		if err := rows.Scan(&quotaID, &path, &usageMode); err != nil {
+			return fmt.Errorf("failed to scan quota path: %w", err)
+		}
+		ids := []string{quotaID}
+		if usageMode == models.UsageModeRollup {
+			ids = pathQuotaIDs(path)
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				lockIDs = append(lockIDs, id)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get quota paths: %w", err)
+	}
+
+	return qs.lockQuotasTx(tx, lockIDs)
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/lib/pq"
+)
+
+// dimensionNamePattern restricts dimension names to short lowercase identifiers
+var dimensionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+// maxDimensionUnitLength matches the quota_dimensions.unit column
+const maxDimensionUnitLength = 20
+
+// dbQueryer is satisfied by both *database.DB and *sql.Tx
+type dbQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// validateDimensionSpecs checks the dimensions requested for a root quota
+func validateDimensionSpecs(specs []models.QuotaDimensionSpec) error {
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		if !dimensionNamePattern.MatchString(spec.Name) {
+			return fmt.Errorf("invalid dimension name: %q", spec.Name)
+		}
+		if seen[spec.Name] {
+			return fmt.Errorf("invalid dimension name: %q is defined twice", spec.Name)
+		}
+		seen[spec.Name] = true
+		if spec.Unit == "" || len(spec.Unit) > maxDimensionUnitLength {
+			return fmt.Errorf("invalid dimension unit for %s: must be 1-%d characters", spec.Name, maxDimensionUnitLength)
+		}
+		if spec.Total < 0 {
+			return fmt.Errorf("invalid dimension total for %s: must not be negative", spec.Name)
+		}
+	}
+	return nil
+}
+
+// validateDimensionAmounts checks a dimension name to amount map. Amounts
+// must be at least minAmount.
+func validateDimensionAmounts(amounts map[string]int64, minAmount int64) error {
+	for name, amount := range amounts {
+		if !dimensionNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid dimension name: %q", name)
+		}
+		if amount < minAmount {
+			return fmt.Errorf("invalid dimension amount for %s: must be at least %d", name, minAmount)
+		}
+	}
+	return nil
+}
+
+// validateUsageRequest checks that a usage call carries some usage, in MB or
+// in at least one dimension
+func validateUsageRequest(request *models.QuotaUsageRequest) error {
+	if request.UsageMB < 0 {
+		return fmt.Errorf("usage_mb must not be negative")
+	}
+	if request.UsageMB == 0 && len(request.Dimensions) == 0 {
+		return fmt.Errorf("usage_mb or dimensions is required")
+	}
+	return validateDimensionAmounts(request.Dimensions, 1)
+}
+
+// sortedDimensionNames returns the keys of amounts in a stable order, so rows
+// are always updated in the same order
+func sortedDimensionNames(amounts map[string]int64) []string {
+	names := make([]string, 0, len(amounts))
+	for name := range amounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadDimensions returns the dimensions of the given quotas, keyed by quota ID
+func loadDimensions(q dbQueryer, quotaIDs []string) (map[string][]models.QuotaDimension, error) {
+	dimensions := make(map[string][]models.QuotaDimension)
+	if len(quotaIDs) == 0 {
+		return dimensions, nil
+	}
+
+	query := `
+		SELECT quota_id, dimension, unit, total, used, allocated, subtree_used
+		FROM quota_dimensions
+		WHERE quota_id = ANY($1)
+		ORDER BY quota_id, dimension
+	`
+
+	rows, err := q.Query(query, pq.Array(quotaIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota dimensions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var quotaID string
+		var dimension models.QuotaDimension
+		err := rows.Scan(&quotaID, &dimension.Name, &dimension.Unit, &dimension.Total,
+			&dimension.Used, &dimension.Allocated, &dimension.SubtreeUsed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan quota dimensions: %w", err)
+		}
+		dimension.Available = dimension.Total - dimension.Used - dimension.Allocated
+		dimensions[quotaID] = append(dimensions[quotaID], dimension)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get quota dimensions: %w", err)
+	}
+
+	return dimensions, nil
+}
+
+func dimensionsByName(dimensions []models.QuotaDimension) map[string]*models.QuotaDimension {
+	byName := make(map[string]*models.QuotaDimension, len(dimensions))
+	for i := range dimensions {
+		byName[dimensions[i].Name] = &dimensions[i]
+	}
+	return byName
+}
+
+// createDimensionsTx inserts the dimensions of a new quota
+func (qs *QuotaService) createDimensionsTx(tx *sql.Tx, quotaID string, dimensions []models.QuotaDimension) error {
+	insertQuery := `
+		INSERT INTO quota_dimensions (quota_id, dimension, unit, total, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+	`
+	for _, dimension := range dimensions {
+		_, err := tx.Exec(insertQuery, quotaID, dimension.Name, dimension.Unit, dimension.Total)
+		if err != nil {
+			return fmt.Errorf("failed to create dimension %s: %w", dimension.Name, err)
+		}
+	}
+	return nil
+}
+
+// allocateDimensionsTx hands part of a parent's dimensions to a new child
+// quota. The child only carries the dimensions it was given. The caller must
+// hold the parent's lock.
+func (qs *QuotaService) allocateDimensionsTx(tx *sql.Tx, parentQuota *models.Quota, childQuotaID string, amounts map[string]int64) ([]models.QuotaDimension, error) {
+	if len(amounts) == 0 {
+		return nil, nil
+	}
+
+	loaded, err := loadDimensions(tx, []string{parentQuota.ID})
+	if err != nil {
+		return nil, err
+	}
+	parentDimensions := dimensionsByName(loaded[parentQuota.ID])
+
+	childDimensions := make([]models.QuotaDimension, 0, len(amounts))
+	for _, name := range sortedDimensionNames(amounts) {
+		amount := amounts[name]
+		parentDimension, exists := parentDimensions[name]
+		if !exists {
+			return nil, fmt.Errorf("dimension %s not found on parent quota", name)
+		}
+		if parentDimension.Available < amount {
+			return nil, fmt.Errorf("insufficient quota: dimension %s available %d %s, requested %d %s",
+				name, parentDimension.Available, parentDimension.Unit, amount, parentDimension.Unit)
+		}
+
+		updateQuery := `UPDATE quota_dimensions SET allocated = allocated + $1, updated_at = NOW() WHERE quota_id = $2 AND dimension = $3`
+		if _, err := tx.Exec(updateQuery, amount, parentQuota.ID, name); err != nil {
+			return nil, fmt.Errorf("failed to update parent dimension %s: %w", name, err)
+		}
+
+		childDimensions = append(childDimensions, models.QuotaDimension{
+			Name:      name,
+			Unit:      parentDimension.Unit,
+			Total:     amount,
+			Available: amount,
+		})
+	}
+
+	if err := qs.createDimensionsTx(tx, childQuotaID, childDimensions); err != nil {
+		return nil, err
+	}
+	return childDimensions, nil
+}
+
+// releaseDimensionsTx returns a released quota's dimension totals to its
+// parent and clears its usage
+func (qs *QuotaService) releaseDimensionsTx(tx *sql.Tx, quotaID string, parentQuotaID *string) error {
+	if parentQuotaID != nil {
+		returnQuery := `
+			UPDATE quota_dimensions p SET allocated = p.allocated - c.total, updated_at = NOW()
+			FROM quota_dimensions c
+			WHERE c.quota_id = $1 AND p.quota_id = $2 AND p.dimension = c.dimension
+		`
+		if _, err := tx.Exec(returnQuery, quotaID, *parentQuotaID); err != nil {
+			return fmt.Errorf("failed to return dimensions to parent quota %s: %w", *parentQuotaID, err)
+		}
+	}
+
+	clearQuery := `UPDATE quota_dimensions SET used = 0, subtree_used = 0, updated_at = NOW() WHERE quota_id = $1`
+	if _, err := tx.Exec(clearQuery, quotaID); err != nil {
+		return fmt.Errorf("failed to clear dimensions of quota %s: %w", quotaID, err)
+	}
+	return nil
+}
+
+// applyDimensionUsageTx adds (sign 1) or removes (sign -1) usage on a quota's
+// dimensions, checking every amount before changing anything. In rollup mode
+// ancestors' subtree_used follows. The caller must hold the locks taken by
+// lockQuotaForUsageTx. It returns the touched dimensions after the change.
+func (qs *QuotaService) applyDimensionUsageTx(tx *sql.Tx, quota *models.Quota, amounts map[string]int64, sign int64) ([]models.QuotaDimension, error) {
+	if len(amounts) == 0 {
+		return nil, nil
+	}
+
+	loaded, err := loadDimensions(tx, []string{quota.ID})
+	if err != nil {
+		return nil, err
+	}
+	dimensions := dimensionsByName(loaded[quota.ID])
+	names := sortedDimensionNames(amounts)
+
+	// 1. Every dimension must fit before any is changed
+	for _, name := range names {
+		amount := amounts[name]
+		dimension, exists := dimensions[name]
+		if !exists {
+			return nil, fmt.Errorf("dimension %s not found on quota %s", name, quota.ID)
+		}
+		if sign > 0 && dimension.Available < amount {
+			return nil, fmt.Errorf("insufficient quota: dimension %s available %d %s, requested %d %s",
+				name, dimension.Available, dimension.Unit, amount, dimension.Unit)
+		}
+		if sign < 0 && dimension.Used < amount {
+			return nil, fmt.Errorf("cannot deallocate %d %s of dimension %s, only %d %s in use",
+				amount, dimension.Unit, name, dimension.Used, dimension.Unit)
+		}
+	}
+
+	// 2. Apply usage (and rollups)
+	touched := make([]models.QuotaDimension, 0, len(names))
+	for _, name := range names {
+		delta := sign * amounts[name]
+
+		updateQuery := `UPDATE quota_dimensions SET used = used + $1, updated_at = NOW() WHERE quota_id = $2 AND dimension = $3`
+		if _, err := tx.Exec(updateQuery, delta, quota.ID, name); err != nil {
+			return nil, fmt.Errorf("failed to update dimension %s usage: %w", name, err)
+		}
+
+		if quota.UsageMode == models.UsageModeRollup {
+			rollupQuery := `UPDATE quota_dimensions SET subtree_used = subtree_used + $1 WHERE quota_id = ANY($2) AND dimension = $3`
+			if _, err := tx.Exec(rollupQuery, delta, pq.Array(pathQuotaIDs(quota.Path)), name); err != nil {
+				return nil, fmt.Errorf("failed to update dimension %s rollups: %w", name, err)
+			}
+		}
+
+		dimension := dimensions[name]
+		dimension.Used += delta
+		dimension.Available -= delta
+		if quota.UsageMode == models.UsageModeRollup {
+			dimension.SubtreeUsed += delta
+		}
+		touched = append(touched, *dimension)
+	}
+
+	return touched, nil
+}
+
+// recordUsageDimensionsTx stores the dimension amounts of a quota_usage row
+func (qs *QuotaService) recordUsageDimensionsTx(tx *sql.Tx, usageID string, amounts map[string]int64) error {
+	insertQuery := `INSERT INTO quota_usage_dimensions (usage_id, dimension, amount) VALUES ($1, $2, $3)`
+	for _, name := range sortedDimensionNames(amounts) {
+		if _, err := tx.Exec(insertQuery, usageID, name, amounts[name]); err != nil {
+			return fmt.Errorf("failed to record dimension %s usage: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// resizeDimensionsTx sets new totals on a quota's existing dimensions. For a
+// child quota the difference is taken from (or returned to) the parent's
+// allocated. The caller must hold the parent's and the quota's locks. It
+// returns before/after totals for the audit log.
+func (qs *QuotaService) resizeDimensionsTx(tx *sql.Tx, quota, parentQuota *models.Quota, totals map[string]int64) (map[string]interface{}, error) {
+	ids := []string{quota.ID}
+	if parentQuota != nil {
+		ids = append(ids, parentQuota.ID)
+	}
+	loaded, err := loadDimensions(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	dimensions := dimensionsByName(loaded[quota.ID])
+	parentDimensions := dimensionsByName(loaded[ids[len(ids)-1]])
+
+	details := make(map[string]interface{}, len(totals))
+	for _, name := range sortedDimensionNames(totals) {
+		newTotal := totals[name]
+		dimension, exists := dimensions[name]
+		if !exists {
+			return nil, fmt.Errorf("dimension %s not found on quota %s", name, quota.ID)
+		}
+		if newTotal < dimension.Used+dimension.Allocated {
+			return nil, fmt.Errorf("cannot shrink dimension %s below used (%d %s) + allocated (%d %s)",
+				name, dimension.Used, dimension.Unit, dimension.Allocated, dimension.Unit)
+		}
+		delta := newTotal - dimension.Total
+
+		if parentQuota != nil {
+			parentDimension, exists := parentDimensions[name]
+			if !exists {
+				return nil, fmt.Errorf("dimension %s not found on parent quota", name)
+			}
+			if delta > parentDimension.Available {
+				return nil, fmt.Errorf("insufficient quota: parent dimension %s available %d %s, requested %d %s",
+					name, parentDimension.Available, parentDimension.Unit, delta, parentDimension.Unit)
+			}
+			updateParentQuery := `UPDATE quota_dimensions SET allocated = allocated + $1, updated_at = NOW() WHERE quota_id = $2 AND dimension = $3`
+			if _, err := tx.Exec(updateParentQuery, delta, parentQuota.ID, name); err != nil {
+				return nil, fmt.Errorf("failed to update parent dimension %s: %w", name, err)
+			}
+		}
+
+		updateQuery := `UPDATE quota_dimensions SET total = $1, updated_at = NOW() WHERE quota_id = $2 AND dimension = $3`
+		if _, err := tx.Exec(updateQuery, newTotal, quota.ID, name); err != nil {
+			return nil, fmt.Errorf("failed to resize dimension %s: %w", name, err)
+		}
+
+		details[name] = map[string]int64{"before_total": dimension.Total, "after_total": newTotal}
+	}
+
+	return details, nil
+}
+
+// moveDimensionsTx moves a quota's dimension totals from the old parent's
+// allocated to the new parent's, which must carry every dimension the quota
+// has. The caller must hold both parents' locks.
+func (qs *QuotaService) moveDimensionsTx(tx *sql.Tx, quotaID, oldParentQuotaID, newParentQuotaID string) error {
+	loaded, err := loadDimensions(tx, []string{quotaID, newParentQuotaID})
+	if err != nil {
+		return err
+	}
+	newParentDimensions := dimensionsByName(loaded[newParentQuotaID])
+
+	updateParentQuery := `UPDATE quota_dimensions SET allocated = allocated + $1, updated_at = NOW() WHERE quota_id = $2 AND dimension = $3`
+	for _, dimension := range loaded[quotaID] {
+		parentDimension, exists := newParentDimensions[dimension.Name]
+		if !exists {
+			return fmt.Errorf("cannot move quota: new parent has no %s dimension", dimension.Name)
+		}
+		if parentDimension.Available < dimension.Total {
+			return fmt.Errorf("insufficient quota: new parent dimension %s available %d %s, requested %d %s",
+				dimension.Name, parentDimension.Available, parentDimension.Unit, dimension.Total, parentDimension.Unit)
+		}
+
+		if _, err := tx.Exec(updateParentQuery, -dimension.Total, oldParentQuotaID, dimension.Name); err != nil {
+			return fmt.Errorf("failed to update old parent dimension %s: %w", dimension.Name, err)
+		}
+		if _, err := tx.Exec(updateParentQuery, dimension.Total, newParentQuotaID, dimension.Name); err != nil {
+			return fmt.Errorf("failed to update new parent dimension %s: %w", dimension.Name, err)
+		}
+	}
+	return nil
+}
+
+// applyDimensionRollupTx adds sign times a quota's stored dimension
+// subtree_used to the same dimensions on the given ancestors
+func (qs *QuotaService) applyDimensionRollupTx(tx *sql.Tx, quotaID string, ancestorIDs []string, sign int64) error {
+	if len(ancestorIDs) == 0 {
+		return nil
+	}
+
+	rollupQuery := `
+		UPDATE quota_dimensions a SET subtree_used = a.subtree_used + $1 * s.subtree_used
+		FROM quota_dimensions s
+		WHERE s.quota_id = $2 AND a.quota_id = ANY($3) AND a.dimension = s.dimension
+	`
+	if _, err := tx.Exec(rollupQuery, sign, quotaID, pq.Array(ancestorIDs)); err != nil {
+		return fmt.Errorf("failed to update dimension rollups: %w", err)
+	}
+	return nil
+}
+
+// recomputeDimensionRollupsTx rebuilds dimension subtree_used for a quota and
+// its descendants from used. pattern is the escaped descendant LIKE pattern.
+func (qs *QuotaService) recomputeDimensionRollupsTx(tx *sql.Tx, quotaID, pattern string) error {
+	recomputeQuery := `
+		UPDATE quota_dimensions d SET subtree_used = COALESCE((
+			SELECT SUM(x.used) FROM quota_dimensions x
+			JOIN quotas xq ON xq.id = x.quota_id
+			WHERE x.dimension = d.dimension AND xq.status != $3
+			  AND (xq.id = q.id OR xq.path LIKE replace(replace(replace(q.path, '\', '\\'), '%', '\%'), '_', '\_') || '/%' ESCAPE '\')
+		), 0)
+		FROM quotas q
+		WHERE q.id = d.quota_id AND (q.id = $1 OR q.path LIKE $2 ESCAPE '\') AND q.status != $3
+	`
+	if _, err := tx.Exec(recomputeQuery, quotaID, pattern, models.QuotaStatusDeleted); err != nil {
+		return fmt.Errorf("failed to recompute dimension rollups: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+)
+
+// maxIdempotencyKeyLength matches the quota_idempotency_keys.idempotency_key column
+const maxIdempotencyKeyLength = 255
+
+// idempotencyPurgeBatchSize bounds how many expired keys one purge deletes
+const idempotencyPurgeBatchSize = 1000
+
+// SetIdempotencyKeyTTL sets how long a stored idempotency key is honoured.
+// Older keys are ignored by lookups and removed by PurgeIdempotencyKeys.
+func (qs *QuotaService) SetIdempotencyKeyTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid idempotency key TTL: %s", ttl)
+	}
+	qs.idempotencyKeyTTL = ttl
+	return nil
+}
+
+// idempotentRequest is the part of a usage request that must match for a
+// repeated idempotency key to count as a retry. Auth fields are left out, as
+// a retry carries a fresh encrypted payload.
+type idempotentRequest struct {
+	UserID     string           `json:"user_id"`
+	Operation  string           `json:"operation"`
+	ResourceID string           `json:"resource_id"`
+	UsageMB    int64            `json:"usage_mb"`
+	Dimensions map[string]int64 `json:"dimensions"`
+	Reason     string           `json:"reason"`
+}
+
+// validateIdempotencyKey checks the optional idempotency key of a usage request
+func validateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("invalid idempotency_key: must be at most %d characters", maxIdempotencyKeyLength)
+	}
+	return nil
+}
+
+// usageRequestHash fingerprints a usage request for idempotency checks
+func usageRequestHash(userID, operation string, request *models.QuotaUsageRequest) (string, error) {
+	payload, err := json.Marshal(idempotentRequest{
+		UserID:     userID,
+		Operation:  operation,
+		ResourceID: request.ResourceID,
+		UsageMB:    request.UsageMB,
+		Dimensions: request.Dimensions,
+		Reason:     request.Reason,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash request: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotencyHash validates a usage request's idempotency key and fingerprints
+// the request. It returns an empty hash when no key is set.
+func (qs *QuotaService) idempotencyHash(userInfo *auth.UserInfo, operation string, request *models.QuotaUsageRequest) (string, error) {
+	if request.IdempotencyKey == "" {
+		return "", nil
+	}
+	if err := validateIdempotencyKey(request.IdempotencyKey); err != nil {
+		return "", err
+	}
+	return usageRequestHash(userInfo.UserID, operation, request)
+}
+
+// lookupIdempotencyKeyTx returns the stored response of an earlier request with
+// the same idempotency key, or found=false when the key is new or older than
+// the retention window. A key reused with a different request is an error.
+// The caller must hold the quota lock so that concurrent retries are serialized.
+func (qs *QuotaService) lookupIdempotencyKeyTx(tx *sql.Tx, quotaID, key, requestHash string) (response []byte, found bool, err error) {
+	query := `
+		SELECT request_hash, response
+		FROM quota_idempotency_keys
+		WHERE quota_id = $1 AND idempotency_key = $2
+		  AND created_at > NOW() - $3 * INTERVAL '1 second'
+	`
+
+	var storedHash string
+	err = tx.QueryRow(query, quotaID, key, qs.idempotencyKeyTTL.Seconds()).Scan(&storedHash, &response)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	if storedHash != requestHash {
+		return nil, false, fmt.Errorf("idempotency key %q was already used with a different request", key)
+	}
+	return response, true, nil
+}
+
+// saveIdempotencyKeyTx stores the result of a request made with an idempotency
+// key. An expired entry for the same key that was not purged yet is replaced.
+func (qs *QuotaService) saveIdempotencyKeyTx(tx *sql.Tx, quotaID, key, operation, requestHash, usageID string, response interface{}) error {
+	var body sql.NullString
+	if response != nil {
+		encoded, err := json.Marshal(response)
+		if err != nil {
+			return fmt.Errorf("failed to encode idempotent response: %w", err)
+		}
+		body = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	insertQuery := `
+		INSERT INTO quota_idempotency_keys (quota_id, idempotency_key, operation, request_hash, usage_id, response, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (quota_id, idempotency_key)
+		DO UPDATE SET operation = EXCLUDED.operation, request_hash = EXCLUDED.request_hash,
+		              usage_id = EXCLUDED.usage_id, response = EXCLUDED.response, created_at = NOW()
+	`
+	_, err := tx.Exec(insertQuery, quotaID, key, operation, requestHash, usageID, body)
+	if err != nil {
+		return fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+	return nil
+}
+
+// replayIdempotentRequestTx fills response with the stored result of an
+// earlier request with the same idempotency key and reports whether there was
+// one. Deallocations stored without a result only set Replayed.
+func (qs *QuotaService) replayIdempotentRequestTx(tx *sql.Tx, quotaID string, request *models.QuotaUsageRequest, requestHash string, response *models.QuotaUsageResponse) (bool, error) {
+	if request.IdempotencyKey == "" {
+		return false, nil
+	}
+	stored, found, err := qs.lookupIdempotencyKeyTx(tx, quotaID, request.IdempotencyKey, requestHash)
+	if err != nil || !found {
+		return false, err
+	}
+	if stored != nil {
+		if err := json.Unmarshal(stored, response); err != nil {
+			return false, fmt.Errorf("failed to decode idempotent response: %w", err)
+		}
+	}
+	response.Replayed = true
+	return true, nil
+}
+
+// PurgeIdempotencyKeys deletes a batch of idempotency keys older than the
+// retention window and returns how many were removed
+func (qs *QuotaService) PurgeIdempotencyKeys() (int64, error) {
+	query := `
+		DELETE FROM quota_idempotency_keys
+		WHERE ctid IN (
+			SELECT ctid FROM quota_idempotency_keys
+			WHERE created_at <= NOW() - $1 * INTERVAL '1 second'
+			LIMIT $2
+		)
+	`
+
+	result, err := qs.db.Exec(query, qs.idempotencyKeyTTL.Seconds(), idempotencyPurgeBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// SetResourceUsage sets the absolute usage of a resource on a quota. The
+// difference to the resource's current balance is allocated or deallocated
+// like a regular usage call.
+func (qs *QuotaService) SetResourceUsage(userInfo *auth.UserInfo, quotaID, resourceID string, request *models.QuotaSetUsageRequest) (*models.QuotaSetUsageResponse, error) {
+	// Check read permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "set quota usage"); err != nil {
+		return nil, err
+	}
+
+	targetMB := *request.UsageMB
+	response := &models.QuotaSetUsageResponse{
+		QuotaUsageResponse: models.QuotaUsageResponse{QuotaID: quotaID, Warnings: []models.QuotaUsageWarning{}},
+		ResourceID:         resourceID,
+		UsageMB:            targetMB,
+	}
+
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Get quota with lock (and its ancestors in rollup mode)
+		quota, err := qs.lockQuotaForUsageTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+
+		// 2. Compute the delta against the resource's balance
+		response.PreviousMB, err = qs.getResourceUsageForUpdateTx(tx, quotaID, resourceID)
+		if err != nil {
+			return err
+		}
+		response.DeltaMB = targetMB - response.PreviousMB
+		response.UsedMB = quota.UsedMB
+		response.AvailableMB = quota.AvailableMB
+		if response.DeltaMB == 0 {
+			return nil
+		}
+
+		// 3. Growth is checked like an allocation
+		operation := models.OperationDeallocate
+		amountMB := -response.DeltaMB
+		if response.DeltaMB > 0 {
+			operation = models.OperationAllocate
+			amountMB = response.DeltaMB
+			if quota.Status == models.QuotaStatusSuspended {
+				return fmt.Errorf("%w: %s", ErrQuotaSuspended, quotaID)
+			}
+			if quota.AllocatableMB < amountMB {
+				return fmt.Errorf("insufficient quota: available %d MB, requested %d MB",
+					quota.AllocatableMB, amountMB)
+			}
+			if err := qs.checkPhysicalCapacityTx(tx, quota, amountMB); err != nil {
+				return err
+			}
+		}
+
+		// 4. Update quota usage (and rollups) and the resource balance
+		beforeMB := quota.UsedMB + quota.AllocatedMB
+		if err := qs.applyUsageDeltaTx(tx, quota, response.DeltaMB); err != nil {
+			return err
+		}
+		if err := qs.applyResourceUsageDeltaTx(tx, quotaID, resourceID, response.DeltaMB); err != nil {
+			return err
+		}
+		response.UsedMB = quota.UsedMB
+		response.AvailableMB = quota.AvailableMB
+		response.Warnings = usageWarnings(quota, beforeMB, quota.UsedMB+quota.AllocatedMB)
+		response.Warning = len(response.Warnings) > 0
+
+		// 5. Record usage
+		usageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
+		usageQuery := `
+			INSERT INTO quota_usage (id, quota_id, user_id, resource_id, usage_mb, operation, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		`
+		_, err = tx.Exec(usageQuery, usageID, quotaID, userInfo.UserID, resourceID,
+			amountMB, operation, request.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+
+		// 6. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "usage_set", userInfo.UserID, nil, map[string]interface{}{
+			"resource_id": resourceID,
+			"before_mb":   response.PreviousMB,
+			"after_mb":    targetMB,
+			"delta_mb":    response.DeltaMB,
+			"reason":      request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		// 7. Record crossed warning limits
+		for _, warning := range response.Warnings {
+			err = qs.createAuditLogTx(tx, quotaID, "threshold_crossed", userInfo.UserID, nil, map[string]interface{}{
+				"type":              warning.Type,
+				"threshold_percent": warning.ThresholdPercent,
+				"limit_mb":          warning.LimitMB,
+				"before_mb":         warning.BeforeMB,
+				"after_mb":          warning.AfterMB,
+				"resource_id":       resourceID,
+			})
+			if err != nil {
+				qs.logger.WithError(err).Warn("Failed to create audit log")
+			}
+		}
+
+		return nil
+	})
+
+	var capacityErr *physicalCapacityError
+	if errors.As(err, &capacityErr) {
+		qs.reportOvercommitExhausted(userInfo, quotaID, capacityErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"quota_id":    quotaID,
+		"resource_id": resourceID,
+		"previous_mb": response.PreviousMB,
+		"usage_mb":    targetMB,
+		"warnings":    response.Warnings,
+	}).Info("Resource usage set successfully")
+
+	return response, nil
+}
+
+// getResourceUsageForUpdateTx locks and returns a resource's usage balance on
+// a quota. A resource without a ledger row has no usage.
+func (qs *QuotaService) getResourceUsageForUpdateTx(tx *sql.Tx, quotaID, resourceID string) (int64, error) {
+	query := `SELECT used_mb FROM quota_resource_usage WHERE quota_id = $1 AND resource_id = $2 FOR UPDATE`
+
+	var usedMB int64
+	err := tx.QueryRow(query, quotaID, resourceID).Scan(&usedMB)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get resource usage: %w", err)
+	}
+	return usedMB, nil
+}
+
+// applyResourceUsageDeltaTx changes a resource's usage balance on a quota.
+// A decrease must be checked against getResourceUsageForUpdateTx first.
+func (qs *QuotaService) applyResourceUsageDeltaTx(tx *sql.Tx, quotaID, resourceID string, deltaMB int64) error {
+	if deltaMB == 0 {
+		return nil
+	}
+	// The insert path would fail the used_mb check for a decrease, even when
+	// the row exists
+	if deltaMB < 0 {
+		updateQuery := `UPDATE quota_resource_usage SET used_mb = used_mb + $1, updated_at = NOW() WHERE quota_id = $2 AND resource_id = $3`
+		if _, err := tx.Exec(updateQuery, deltaMB, quotaID, resourceID); err != nil {
+			return fmt.Errorf("failed to update resource usage: %w", err)
+		}
+		return nil
+	}
+
+	upsertQuery := `
+		INSERT INTO quota_resource_usage (quota_id, resource_id, used_mb, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (quota_id, resource_id)
+		DO UPDATE SET used_mb = quota_resource_usage.used_mb + EXCLUDED.used_mb, updated_at = NOW()
+	`
+	if _, err := tx.Exec(upsertQuery, quotaID, resourceID, deltaMB); err != nil {
+		return fmt.Errorf("failed to update resource usage: %w", err)
+	}
+	return nil
+}
+
+// checkResourceDeallocationTx verifies that a resource holds at least
+// deallocMB on a quota
+func (qs *QuotaService) checkResourceDeallocationTx(tx *sql.Tx, quotaID, resourceID string, deallocMB int64) error {
+	if deallocMB == 0 {
+		return nil
+	}
+	usedMB, err := qs.getResourceUsageForUpdateTx(tx, quotaID, resourceID)
+	if err != nil {
+		return err
+	}
+	if usedMB < deallocMB {
+		return fmt.Errorf("cannot deallocate %d MB from resource %s, only %d MB in use", deallocMB, resourceID, usedMB)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// GetQuotaLimits returns a quota's hard limit and warning limits
+func (qs *QuotaService) GetQuotaLimits(userInfo *auth.UserInfo, quotaID string) (*models.QuotaLimits, error) {
+	quota, err := qs.GetQuota(userInfo, quotaID)
+	if err != nil {
+		return nil, err
+	}
+	return quotaLimits(quota), nil
+}
+
+// UpdateQuotaLimits replaces a quota's soft limit and warning thresholds.
+// Thresholds are percentages of total_mb; they are de-duplicated and sorted.
+func (qs *QuotaService) UpdateQuotaLimits(userInfo *auth.UserInfo, quotaID string, request *models.QuotaLimitsRequest) (*models.QuotaLimits, error) {
+	// Check admin permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionAdmin}, "update quota limits"); err != nil {
+		return nil, err
+	}
+
+	thresholds, err := normalizeWarningThresholds(request.WarningThresholds)
+	if err != nil {
+		return nil, err
+	}
+
+	var quota *models.Quota
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Get quota with lock
+		quota, err = qs.getQuotaForUpdateTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+
+		// 2. Validate the soft limit against the hard limit
+		if request.SoftLimitMB != nil && (*request.SoftLimitMB <= 0 || *request.SoftLimitMB > quota.TotalMB) {
+			return fmt.Errorf("invalid soft_limit_mb: must be between 1 and total_mb (%d MB)", quota.TotalMB)
+		}
+
+		// 3. Update limits
+		updateQuery := `UPDATE quotas SET soft_limit_mb = $1, warning_thresholds = $2, updated_at = NOW() WHERE id = $3`
+		_, err = tx.Exec(updateQuery, request.SoftLimitMB, pq.Array(thresholds), quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to update quota limits: %w", err)
+		}
+
+		// 4. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "limits_update", userInfo.UserID, nil, map[string]interface{}{
+			"before_soft_limit_mb":      quota.SoftLimitMB,
+			"after_soft_limit_mb":       request.SoftLimitMB,
+			"before_warning_thresholds": quota.WarningThresholds,
+			"after_warning_thresholds":  thresholds,
+			"reason":                    request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		quota.SoftLimitMB = request.SoftLimitMB
+		quota.WarningThresholds = thresholds
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"quota_id":           quotaID,
+		"soft_limit_mb":      request.SoftLimitMB,
+		"warning_thresholds": thresholds,
+	}).Info("Quota limits updated successfully")
+
+	return quotaLimits(quota), nil
+}
+
+func quotaLimits(quota *models.Quota) *models.QuotaLimits {
+	limits := &models.QuotaLimits{
+		QuotaID:           quota.ID,
+		TotalMB:           quota.TotalMB,
+		SoftLimitMB:       quota.SoftLimitMB,
+		WarningThresholds: quota.WarningThresholds,
+		ConsumedMB:        quota.UsedMB + quota.AllocatedMB,
+	}
+	if limits.WarningThresholds == nil {
+		limits.WarningThresholds = []int64{}
+	}
+	return limits
+}
+
+// normalizeWarningThresholds validates threshold percentages and returns them
+// sorted without duplicates
+func normalizeWarningThresholds(thresholds []int64) ([]int64, error) {
+	seen := make(map[int64]bool)
+	normalized := []int64{}
+	for _, percent := range thresholds {
+		if percent < 1 || percent > 100 {
+			return nil, fmt.Errorf("invalid warning threshold %d: must be between 1 and 100", percent)
+		}
+		if !seen[percent] {
+			seen[percent] = true
+			normalized = append(normalized, percent)
+		}
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i] < normalized[j] })
+	return normalized, nil
+}
+
+// usageWarnings returns the warning limits crossed when a quota's consumption
+// (used + allocated) grows from beforeMB to afterMB
+func usageWarnings(quota *models.Quota, beforeMB, afterMB int64) []models.QuotaUsageWarning {
+	warnings := []models.QuotaUsageWarning{}
+	for _, percent := range quota.WarningThresholds {
+		limitMB := (quota.TotalMB*percent + 99) / 100
+		if beforeMB < limitMB && afterMB >= limitMB {
+			warnings = append(warnings, models.QuotaUsageWarning{
+				Type:             models.UsageWarningThreshold,
+				ThresholdPercent: percent,
+				LimitMB:          limitMB,
+				BeforeMB:         beforeMB,
+				AfterMB:          afterMB,
+			})
+		}
+	}
+	if quota.SoftLimitMB != nil && beforeMB <= *quota.SoftLimitMB && afterMB > *quota.SoftLimitMB {
+		warnings = append(warnings, models.QuotaUsageWarning{
+			Type:     models.UsageWarningSoftLimit,
+			LimitMB:  *quota.SoftLimitMB,
+			BeforeMB: beforeMB,
+			AfterMB:  afterMB,
+		})
+	}
+	return warnings
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -14,19 +15,54 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Authorization enforcement modes
+const (
+	AuthzModeEnforce   = "enforce"    // deny requests that fail permission checks
+	AuthzModeAuditOnly = "audit-only" // log and audit failed checks, but allow
+	AuthzModeOff       = "off"        // skip permission checks entirely
+)
+
+// ErrQuotaSuspended is returned when allocating from a suspended quota
+var ErrQuotaSuspended = errors.New("quota is suspended")
+
 // QuotaService handles quota operations
 type QuotaService struct {
 	db         *database.DB
-	authClient *auth.AuthClient
+	authorizer auth.Authorizer
 	logger     *logrus.Logger
+	authzMode  string
+
+	// Reservation lifetimes
+	reservationDefaultTTL time.Duration
+	reservationMaxTTL     time.Duration
+
+	// How long a stored idempotency key is honoured before it is purged
+	idempotencyKeyTTL time.Duration
 }
 
 // NewQuotaService creates a new quota service
-func NewQuotaService(db *database.DB, authClient *auth.AuthClient, logger *logrus.Logger) *QuotaService {
+func NewQuotaService(db *database.DB, authorizer auth.Authorizer, logger *logrus.Logger) *QuotaService {
 	return &QuotaService{
 		db:         db,
-		authClient: authClient,
+		authorizer: authorizer,
 		logger:     logger,
+		authzMode:  AuthzModeEnforce,
+
+		reservationDefaultTTL: 5 * time.Minute,
+		reservationMaxTTL:     time.Hour,
+
+		idempotencyKeyTTL: 24 * time.Hour,
+	}
+}
+
+// SetAuthorizationMode sets how permission check failures are handled
+func (qs *QuotaService) SetAuthorizationMode(mode string) error {
+	switch mode {
+	case AuthzModeEnforce, AuthzModeAuditOnly, AuthzModeOff:
+		qs.authzMode = mode
+		return nil
+	default:
+		return fmt.Errorf("invalid authorization mode: %s", mode)
 	}
 }
 
@@ -46,61 +82,91 @@ func (qs *QuotaService) CreateQuota(userInfo *auth.UserInfo, request *models.Quo
 		return nil, fmt.Errorf("team_id is required for team quota")
 	}
 
+	usageMode := request.UsageMode
+	if usageMode == "" {
+		usageMode = models.UsageModeDirect
+	}
+	if usageMode != models.UsageModeDirect && usageMode != models.UsageModeRollup {
+		return nil, fmt.Errorf("invalid usage mode: %s", usageMode)
+	}
+
+	overcommitRatio, err := validateOvercommitRatio(request.OvercommitRatio, usageMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateDimensionSpecs(request.Dimensions); err != nil {
+		return nil, err
+	}
+
 	// Generate quota ID
 	quotaID := fmt.Sprintf("quota_%s", strings.ToLower(uuid.New().String()[:13]))
 
+	// Register the quota with the permission engine so the creator owns it
+	if err := qs.registerQuotaResource(userInfo, quotaID, request.Name, request.Description, nil); err != nil {
+		return nil, err
+	}
+
 	// Create quota within transaction
 	quota := &models.Quota{}
-	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
 		// 1. Create quota record
 		quota = &models.Quota{
-			ID:             quotaID,
-			Name:           request.Name,
-			Description:    request.Description,
-			Type:           request.Type,
-			TotalMB:        request.TotalMB,
-			UsedMB:         0,
-			AllocatedMB:    0,
-			ParentQuotaID:  nil, // Root quota
-			Level:          0,   // Root level
-			Path:           "/" + quotaID,
-			OwnerID:        userInfo.UserID,
-			OrganizationID: userInfo.OrganizationID,
-			TeamID:         request.TeamID,
-			Status:         models.QuotaStatusActive,
-			CreatedAt:      time.Now(),
-			UpdatedAt:      time.Now(),
+			ID:              quotaID,
+			Name:            request.Name,
+			Description:     request.Description,
+			Type:            request.Type,
+			TotalMB:         request.TotalMB,
+			UsedMB:          0,
+			AllocatedMB:     0,
+			ParentQuotaID:   nil, // Root quota
+			Level:           0,   // Root level
+			Path:            "/" + quotaID,
+			OwnerID:         userInfo.UserID,
+			OrganizationID:  userInfo.OrganizationID,
+			TeamID:          request.TeamID,
+			Status:          models.QuotaStatusActive,
+			UsageMode:       usageMode,
+			OvercommitRatio: overcommitRatio,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
 		}
 
 		insertQuery := `
 			INSERT INTO quotas (id, name, description, type, total_mb, used_mb, allocated_mb, 
-			                   parent_quota_id, level, path, owner_id, organization_id, team_id, status, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			                   parent_quota_id, level, path, owner_id, organization_id, team_id, status, created_at, updated_at,
+			                   usage_mode, overcommit_ratio)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 		`
 
 		_, err := tx.Exec(insertQuery, quota.ID, quota.Name, quota.Description, quota.Type,
 			quota.TotalMB, quota.UsedMB, quota.AllocatedMB, quota.ParentQuotaID, quota.Level,
 			quota.Path, quota.OwnerID, quota.OrganizationID, quota.TeamID, quota.Status,
-			quota.CreatedAt, quota.UpdatedAt)
+			quota.CreatedAt, quota.UpdatedAt, quota.UsageMode, quota.OvercommitRatio)
 		if err != nil {
 			return fmt.Errorf("failed to create quota: %w", err)
 		}
 
-		// 2. Create quota resource in auth service (disabled for now)
-		// TODO: Re-enable when auth service is fully configured
-		/*
-		err = qs.authClient.CreateResource(userInfo, quotaID, "quota", quota.Name, quota.Description)
-		if err != nil {
-			return fmt.Errorf("failed to create quota resource in auth service: %w", err)
+		for _, spec := range request.Dimensions {
+			quota.Dimensions = append(quota.Dimensions, models.QuotaDimension{
+				Name:      spec.Name,
+				Unit:      spec.Unit,
+				Total:     spec.Total,
+				Available: spec.Total,
+			})
+		}
+		if err := qs.createDimensionsTx(tx, quotaID, quota.Dimensions); err != nil {
+			return err
 		}
-		*/
-		qs.logger.WithField("quota_id", quotaID).Info("Skipped auth service resource creation for testing")
 
-		// 3. Create audit log
+		// 2. Create audit log
 		err = qs.createAuditLogTx(tx, quotaID, "create", userInfo.UserID, nil, map[string]interface{}{
-			"name":     quota.Name,
-			"type":     quota.Type,
-			"total_mb": quota.TotalMB,
+			"name":             quota.Name,
+			"type":             quota.Type,
+			"total_mb":         quota.TotalMB,
+			"usage_mode":       quota.UsageMode,
+			"overcommit_ratio": quota.OvercommitRatio,
+			"dimensions":       quota.Dimensions,
 		})
 		if err != nil {
 			qs.logger.WithError(err).Warn("Failed to create audit log")
@@ -110,6 +176,7 @@ func (qs *QuotaService) CreateQuota(userInfo *auth.UserInfo, request *models.Quo
 	})
 
 	if err != nil {
+		qs.unregisterQuotaResource(userInfo, quotaID, nil)
 		return nil, err
 	}
 
@@ -138,7 +205,7 @@ func (qs *QuotaService) ListQuotas(userInfo *auth.UserInfo, page, pageSize int,
 	offset := (page - 1) * pageSize
 
 	// Build query with filters
-	whereClause := "WHERE organization_id = $1 AND status = 'active'"
+	whereClause := "WHERE organization_id = $1 AND status != 'deleted'"
 	args := []interface{}{userInfo.OrganizationID}
 	argIndex := 2
 
@@ -160,7 +227,8 @@ func (qs *QuotaService) ListQuotas(userInfo *auth.UserInfo, page, pageSize int,
 	query := fmt.Sprintf(`
 		SELECT id, name, description, type, total_mb, used_mb, allocated_mb, 
 		       parent_quota_id, level, path, owner_id, organization_id, team_id, 
-		       status, created_at, updated_at, deleted_at
+		       status, created_at, updated_at, deleted_at, usage_mode, subtree_used_mb, overcommit_ratio,
+		       soft_limit_mb, warning_thresholds, reserved_mb
 		FROM quotas %s 
 		ORDER BY created_at DESC 
 		LIMIT $%d OFFSET $%d
@@ -187,6 +255,8 @@ func (qs *QuotaService) ListQuotas(userInfo *auth.UserInfo, page, pageSize int,
 			&parentQuotaID, &quota.Level, &quota.Path,
 			&quota.OwnerID, &quota.OrganizationID, &teamID,
 			&quota.Status, &quota.CreatedAt, &quota.UpdatedAt, &deletedAt,
+			&quota.UsageMode, &quota.SubtreeUsedMB, &quota.OvercommitRatio,
+			&quota.SoftLimitMB, pq.Array(&quota.WarningThresholds), &quota.ReservedMB,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan quota: %w", err)
@@ -204,7 +274,7 @@ func (qs *QuotaService) ListQuotas(userInfo *auth.UserInfo, page, pageSize int,
 		}
 
 		// Calculate available_mb
-		quota.AvailableMB = quota.TotalMB - quota.UsedMB - quota.AllocatedMB
+		quota.ComputeCapacity()
 
 		quotas = append(quotas, quota)
 	}
@@ -213,6 +283,19 @@ func (qs *QuotaService) ListQuotas(userInfo *auth.UserInfo, page, pageSize int,
 		return nil, fmt.Errorf("error iterating quota rows: %w", err)
 	}
 
+	// Attach resource dimensions
+	quotaIDs := make([]string, 0, len(quotas))
+	for _, quota := range quotas {
+		quotaIDs = append(quotaIDs, quota.ID)
+	}
+	dimensions, err := loadDimensions(qs.db, quotaIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range quotas {
+		quotas[i].Dimensions = dimensions[quotas[i].ID]
+	}
+
 	// Calculate total pages
 	totalPages := (totalCount + pageSize - 1) / pageSize
 
@@ -237,125 +320,105 @@ func (qs *QuotaService) ListQuotas(userInfo *auth.UserInfo, page, pageSize int,
 
 // AllocateQuota allocates a sub-quota from a parent quota
 func (qs *QuotaService) AllocateQuota(userInfo *auth.UserInfo, parentQuotaID string, request *models.QuotaAllocateRequest) (*models.Quota, error) {
-	// Check admin permission on parent quota (disabled for testing)
-	// TODO: Re-enable when auth service is fully configured
-	/*
-	hasPermission, err := qs.authClient.CheckPermission(userInfo, parentQuotaID, []string{auth.QuotaPermissionAdmin})
-	if err != nil {
-		return nil, fmt.Errorf("failed to check permissions: %w", err)
-	}
-	if !hasPermission {
-		return nil, fmt.Errorf("insufficient permissions to allocate quota")
+	// Check admin permission on parent quota
+	if _, err := qs.authorize(userInfo, parentQuotaID, []string{auth.QuotaPermissionAdmin}, "allocate quota"); err != nil {
+		return nil, err
 	}
-	*/
-	qs.logger.WithField("parent_quota_id", parentQuotaID).Info("Skipped permission check for testing")
 
 	// Validate request
 	if request.AllocateMB <= 0 {
 		return nil, fmt.Errorf("allocate_mb must be greater than 0")
 	}
+	if err := validateDimensionAmounts(request.Dimensions, 1); err != nil {
+		return nil, err
+	}
 
 	// Generate child quota ID
 	childQuotaID := fmt.Sprintf("quota_%s", strings.ToLower(uuid.New().String()[:13]))
 
-	// Allocate quota within transaction
-	childQuota := &models.Quota{}
+	// Reject requests that cannot succeed before touching the permission engine
 	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
-		// 1. Get parent quota with lock
-		parentQuota, err := qs.getQuotaForUpdateTx(tx, parentQuotaID)
-		if err != nil {
-			return fmt.Errorf("failed to get parent quota: %w", err)
-		}
+		_, _, err := qs.checkAllocationTx(tx, parentQuotaID, request)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// 2. Check available capacity
-		if parentQuota.AvailableMB < request.AllocateMB {
-			return fmt.Errorf("insufficient quota: available %d MB, requested %d MB",
-				parentQuota.AvailableMB, request.AllocateMB)
-		}
+	// Register the child quota and grant its admins. This talks to the auth
+	// service, so it runs before the parent is locked; a failed allocation
+	// below unregisters it again.
+	if err := qs.registerQuotaResource(userInfo, childQuotaID, request.Name, request.Description, request.AdminUserIDs); err != nil {
+		return nil, err
+	}
 
-		// 3. Validate hierarchy rules
-		err = qs.validateAllocationRules(parentQuota, request)
+	// Allocate quota within transaction
+	childQuota := &models.Quota{}
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock the parent and re-check capacity, overcommit and hierarchy rules
+		parentQuota, overcommitRatio, err := qs.checkAllocationTx(tx, parentQuotaID, request)
 		if err != nil {
 			return err
 		}
 
-		// 4. Create child quota
+		// 2. Create child quota
 		childQuota = &models.Quota{
-			ID:             childQuotaID,
-			Name:           request.Name,
-			Description:    request.Description,
-			Type:           request.Type,
-			TotalMB:        request.AllocateMB,
-			UsedMB:         0,
-			AllocatedMB:    0,
-			ParentQuotaID:  &parentQuotaID,
-			Level:          parentQuota.Level + 1,
-			Path:           parentQuota.Path + "/" + childQuotaID,
-			OwnerID:        parentQuota.OwnerID, // Inherit owner from parent
-			OrganizationID: parentQuota.OrganizationID,
-			TeamID:         qs.determineTeamID(parentQuota, request),
-			Status:         models.QuotaStatusActive,
-			CreatedAt:      time.Now(),
-			UpdatedAt:      time.Now(),
+			ID:              childQuotaID,
+			Name:            request.Name,
+			Description:     request.Description,
+			Type:            request.Type,
+			TotalMB:         request.AllocateMB,
+			UsedMB:          0,
+			AllocatedMB:     0,
+			ParentQuotaID:   &parentQuotaID,
+			Level:           parentQuota.Level + 1,
+			Path:            parentQuota.Path + "/" + childQuotaID,
+			OwnerID:         parentQuota.OwnerID, // Inherit owner from parent
+			OrganizationID:  parentQuota.OrganizationID,
+			TeamID:          qs.determineTeamID(parentQuota, request),
+			Status:          models.QuotaStatusActive,
+			UsageMode:       parentQuota.UsageMode,
+			OvercommitRatio: overcommitRatio,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
 		}
 
 		insertQuery := `
 			INSERT INTO quotas (id, name, description, type, total_mb, used_mb, allocated_mb, 
-			                   parent_quota_id, level, path, owner_id, organization_id, team_id, status, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			                   parent_quota_id, level, path, owner_id, organization_id, team_id, status, created_at, updated_at,
+			                   usage_mode, overcommit_ratio)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
 		`
 
 		_, err = tx.Exec(insertQuery, childQuota.ID, childQuota.Name, childQuota.Description, childQuota.Type,
 			childQuota.TotalMB, childQuota.UsedMB, childQuota.AllocatedMB, childQuota.ParentQuotaID, childQuota.Level,
 			childQuota.Path, childQuota.OwnerID, childQuota.OrganizationID, childQuota.TeamID, childQuota.Status,
-			childQuota.CreatedAt, childQuota.UpdatedAt)
+			childQuota.CreatedAt, childQuota.UpdatedAt, childQuota.UsageMode, childQuota.OvercommitRatio)
 		if err != nil {
 			return fmt.Errorf("failed to create child quota: %w", err)
 		}
 
-		// 5. Update parent quota allocated_mb
+		// 3. Update parent quota allocated_mb, and allocated on any dimensions
+		// handed to the child
 		updateQuery := `UPDATE quotas SET allocated_mb = allocated_mb + $1, updated_at = NOW() WHERE id = $2`
 		_, err = tx.Exec(updateQuery, request.AllocateMB, parentQuotaID)
 		if err != nil {
 			return fmt.Errorf("failed to update parent quota: %w", err)
 		}
-
-		// 6. Create quota resource in auth service (disabled for testing)
-		// TODO: Re-enable when auth service is fully configured
-		/*
-		err = qs.authClient.CreateResource(userInfo, childQuotaID, "quota", childQuota.Name, childQuota.Description)
+		childQuota.Dimensions, err = qs.allocateDimensionsTx(tx, parentQuota, childQuotaID, request.Dimensions)
 		if err != nil {
-			return fmt.Errorf("failed to create child quota resource in auth service: %w", err)
-		}
-		*/
-		qs.logger.WithField("child_quota_id", childQuotaID).Info("Skipped auth service resource creation for testing")
-
-		// 7. Grant admin permissions to specified users (disabled for testing)
-		// TODO: Re-enable when auth service is fully configured
-		/*
-		for _, adminUserID := range request.AdminUserIDs {
-			err = qs.authClient.GrantPermission(userInfo, adminUserID, childQuotaID, []string{auth.QuotaPermissionAdmin})
-			if err != nil {
-				qs.logger.WithError(err).WithFields(logrus.Fields{
-					"child_quota_id": childQuotaID,
-					"admin_user_id":  adminUserID,
-				}).Warn("Failed to grant admin permission")
-			}
-		}
-		*/
-		if len(request.AdminUserIDs) > 0 {
-			qs.logger.WithFields(logrus.Fields{
-				"child_quota_id": childQuotaID,
-				"admin_users":    request.AdminUserIDs,
-			}).Info("Skipped permission grants for testing")
+			return err
 		}
 
-		// 8. Create audit log
+		// 4. Create audit log
 		err = qs.createAuditLogTx(tx, childQuotaID, "allocate", userInfo.UserID, nil, map[string]interface{}{
-			"parent_quota_id": parentQuotaID,
-			"allocated_mb":    request.AllocateMB,
-			"name":            childQuota.Name,
-			"type":            childQuota.Type,
+			"parent_quota_id":  parentQuotaID,
+			"allocated_mb":     request.AllocateMB,
+			"name":             childQuota.Name,
+			"type":             childQuota.Type,
+			"admin_user_ids":   request.AdminUserIDs,
+			"overcommit_ratio": childQuota.OvercommitRatio,
+			"dimensions":       request.Dimensions,
 		})
 		if err != nil {
 			qs.logger.WithError(err).Warn("Failed to create audit log")
@@ -365,6 +428,7 @@ func (qs *QuotaService) AllocateQuota(userInfo *auth.UserInfo, parentQuotaID str
 	})
 
 	if err != nil {
+		qs.unregisterQuotaResource(userInfo, childQuotaID, request.AdminUserIDs)
 		return nil, err
 	}
 
@@ -381,15 +445,11 @@ func (qs *QuotaService) AllocateQuota(userInfo *auth.UserInfo, parentQuotaID str
 // ReleaseQuota releases a quota and returns its capacity to parent
 func (qs *QuotaService) ReleaseQuota(userInfo *auth.UserInfo, quotaID string) error {
 	// Check admin permission
-	hasPermission, err := qs.authClient.CheckPermission(userInfo, quotaID, []string{auth.QuotaPermissionAdmin})
-	if err != nil {
-		return fmt.Errorf("failed to check permissions: %w", err)
-	}
-	if !hasPermission {
-		return fmt.Errorf("insufficient permissions to release quota")
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionAdmin}, "release quota"); err != nil {
+		return err
 	}
 
-	return qs.db.WithTransaction(func(tx *sql.Tx) error {
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
 		// 1. Get quota with lock
 		quota, err := qs.getQuotaForUpdateTx(tx, quotaID)
 		if err != nil {
@@ -401,6 +461,16 @@ func (qs *QuotaService) ReleaseQuota(userInfo *auth.UserInfo, quotaID string) er
 			return fmt.Errorf("cannot release quota with active usage (%d MB) or allocations (%d MB)",
 				quota.UsedMB, quota.AllocatedMB)
 		}
+		dimensions, err := loadDimensions(tx, []string{quotaID})
+		if err != nil {
+			return err
+		}
+		for _, dimension := range dimensions[quotaID] {
+			if dimension.Used > 0 || dimension.Allocated > 0 {
+				return fmt.Errorf("cannot release quota with active usage (%d %s) or allocations (%d %s) of dimension %s",
+					dimension.Used, dimension.Unit, dimension.Allocated, dimension.Unit, dimension.Name)
+			}
+		}
 
 		// 3. Return capacity to parent (if exists)
 		if quota.ParentQuotaID != nil {
@@ -410,6 +480,9 @@ func (qs *QuotaService) ReleaseQuota(userInfo *auth.UserInfo, quotaID string) er
 				return fmt.Errorf("failed to update parent quota: %w", err)
 			}
 		}
+		if err := qs.releaseDimensionsTx(tx, quotaID, quota.ParentQuotaID); err != nil {
+			return err
+		}
 
 		// 4. Soft delete quota
 		deleteQuery := `UPDATE quotas SET status = $1, deleted_at = NOW(), updated_at = NOW() WHERE id = $2`
@@ -422,6 +495,7 @@ func (qs *QuotaService) ReleaseQuota(userInfo *auth.UserInfo, quotaID string) er
 		err = qs.createAuditLogTx(tx, quotaID, "release", userInfo.UserID, nil, map[string]interface{}{
 			"parent_quota_id": quota.ParentQuotaID,
 			"returned_mb":     quota.TotalMB,
+			"dimensions":      dimensions[quotaID],
 		})
 		if err != nil {
 			qs.logger.WithError(err).Warn("Failed to create audit log")
@@ -429,264 +503,1479 @@ func (qs *QuotaService) ReleaseQuota(userInfo *auth.UserInfo, quotaID string) er
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	// Released quotas must not keep serving cached grants
+	qs.authorizer.InvalidatePermissions(quotaID)
+
+	return nil
 }
 
-// AllocateUsage allocates usage to a quota
-func (qs *QuotaService) AllocateUsage(userInfo *auth.UserInfo, quotaID string, request *models.QuotaUsageRequest) error {
-	// Check read permission (disabled for testing)
-	// TODO: Re-enable when auth service is fully configured
-	/*
-	hasPermission, err := qs.authClient.CheckPermission(userInfo, quotaID, []string{auth.QuotaPermissionRead})
+// ReleaseQuotaTree releases a quota together with its whole subtree. Outstanding
+// usage is force-deallocated per resource, and quotas are released children
+// before parents in a single transaction. With dryRun nothing is written and
+// the returned plan describes what would happen.
+func (qs *QuotaService) ReleaseQuotaTree(userInfo *auth.UserInfo, quotaID string, dryRun bool, reason string) (*models.QuotaReleasePlan, error) {
+	// Check admin permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionAdmin}, "release quota"); err != nil {
+		return nil, err
+	}
+
+	chain, err := qs.getQuotaChain(quotaID)
 	if err != nil {
-		return fmt.Errorf("failed to check permissions: %w", err)
+		return nil, err
 	}
-	if !hasPermission {
-		return fmt.Errorf("insufficient permissions to use quota")
+	var parentQuotaID string
+	if len(chain) > 1 {
+		parentQuotaID = chain[len(chain)-2]
 	}
-	*/
-	qs.logger.WithField("quota_id", quotaID).Info("Skipped permission check for usage allocation")
 
-	return qs.db.WithTransaction(func(tx *sql.Tx) error {
-		// 1. Get quota with lock
+	plan := &models.QuotaReleasePlan{
+		QuotaID: quotaID,
+		DryRun:  dryRun,
+		Steps:   []models.QuotaReleaseStep{},
+	}
+	if parentQuotaID != "" {
+		plan.ParentQuotaID = &parentQuotaID
+	}
+
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock ancestors (root first), then the quota, then its descendants.
+		// The parent gets capacity back and, in rollup mode, every ancestor's
+		// subtree_used_mb shrinks.
+		if err := qs.lockQuotasTx(tx, chain[:len(chain)-1]); err != nil {
+			return err
+		}
 		quota, err := qs.getQuotaForUpdateTx(tx, quotaID)
 		if err != nil {
 			return fmt.Errorf("failed to get quota: %w", err)
 		}
 
-		// 2. Check available capacity
-		availableForUsage := quota.TotalMB - quota.UsedMB - quota.AllocatedMB
-		if availableForUsage < request.UsageMB {
-			return fmt.Errorf("insufficient quota: available %d MB, requested %d MB",
-				availableForUsage, request.UsageMB)
+		// 2. Lock the subtree parent before child and load it deepest first
+		subtree, err := qs.getSubtreeForUpdateTx(tx, quota)
+		if err != nil {
+			return err
 		}
 
-		// 3. Update quota usage
-		updateQuery := `UPDATE quotas SET used_mb = used_mb + $1, updated_at = NOW() WHERE id = $2`
-		_, err = tx.Exec(updateQuery, request.UsageMB, quotaID)
+		// 3. Build the plan, including per-resource outstanding usage
+		subtreeIDs := make([]string, 0, len(subtree))
+		for _, q := range subtree {
+			subtreeIDs = append(subtreeIDs, q.ID)
+		}
+		dimensions, err := loadDimensions(tx, subtreeIDs)
 		if err != nil {
-			return fmt.Errorf("failed to update quota usage: %w", err)
+			return err
+		}
+		for _, q := range subtree {
+			usage, err := qs.outstandingUsageTx(tx, q)
+			if err != nil {
+				return err
+			}
+			plan.Steps = append(plan.Steps, models.QuotaReleaseStep{
+				QuotaID:       q.ID,
+				ParentQuotaID: q.ParentQuotaID,
+				Level:         q.Level,
+				TotalMB:       q.TotalMB,
+				ForcedUsage:   usage,
+				Dimensions:    dimensions[q.ID],
+			})
+			plan.ForcedUsageMB += q.UsedMB
+		}
+		if parentQuotaID != "" {
+			plan.ReturnedMB = quota.TotalMB
 		}
 
-		// 4. Record usage
-		usageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
-		usageQuery := `
-			INSERT INTO quota_usage (id, quota_id, user_id, resource_id, usage_mb, operation, reason, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
-		`
-		_, err = tx.Exec(usageQuery, usageID, quotaID, userInfo.UserID, request.ResourceID,
-			request.UsageMB, models.OperationAllocate, request.Reason)
-		if err != nil {
-			return fmt.Errorf("failed to record usage: %w", err)
+		if dryRun {
+			return nil
 		}
 
-		// 5. Create audit log
-		err = qs.createAuditLogTx(tx, quotaID, "usage_allocate", userInfo.UserID, nil, map[string]interface{}{
-			"resource_id": request.ResourceID,
-			"usage_mb":    request.UsageMB,
-			"reason":      request.Reason,
-		})
-		if err != nil {
-			qs.logger.WithError(err).Warn("Failed to create audit log")
+		// 4. Remove the forced usage from the ancestors' rollups, while the
+		// quota's dimension rollups still hold the subtree's usage
+		if quota.UsageMode == models.UsageModeRollup {
+			if err := qs.applyRollupDeltaTx(tx, chain[:len(chain)-1], -plan.ForcedUsageMB); err != nil {
+				return err
+			}
+			if err := qs.applyDimensionRollupTx(tx, quotaID, chain[:len(chain)-1], -1); err != nil {
+				return err
+			}
+		}
+
+		// 5. Release children before parents
+		for _, step := range plan.Steps {
+			if err := qs.releaseStepTx(tx, userInfo, quotaID, step, reason); err != nil {
+				return err
+			}
 		}
 
 		return nil
 	})
-}
-
-// DeallocateUsage deallocates usage from a quota
-func (qs *QuotaService) DeallocateUsage(userInfo *auth.UserInfo, quotaID string, request *models.QuotaUsageRequest) error {
-	// Check read permission (disabled for testing)
-	// TODO: Re-enable when auth service is fully configured
-	/*
-	hasPermission, err := qs.authClient.CheckPermission(userInfo, quotaID, []string{auth.QuotaPermissionRead})
 	if err != nil {
-		return fmt.Errorf("failed to check permissions: %w", err)
+		return nil, err
 	}
-	if !hasPermission {
-		return fmt.Errorf("insufficient permissions to deallocate quota usage")
+
+	if dryRun {
+		return plan, nil
 	}
-	*/
-	qs.logger.WithField("quota_id", quotaID).Info("Skipped permission check for usage deallocation")
 
-	return qs.db.WithTransaction(func(tx *sql.Tx) error {
-		// 1. Get quota with lock
-		quota, err := qs.getQuotaForUpdateTx(tx, quotaID)
-		if err != nil {
-			return fmt.Errorf("failed to get quota: %w", err)
-		}
+	// Released quotas must not keep serving cached grants
+	for _, step := range plan.Steps {
+		qs.authorizer.InvalidatePermissions(step.QuotaID)
+	}
 
-		// 2. Check if enough usage to deallocate
-		if quota.UsedMB < request.UsageMB {
-			return fmt.Errorf("cannot deallocate %d MB, only %d MB in use", request.UsageMB, quota.UsedMB)
-		}
+	qs.logger.WithFields(logrus.Fields{
+		"quota_id":        quotaID,
+		"released_count":  len(plan.Steps),
+		"forced_usage_mb": plan.ForcedUsageMB,
+		"returned_mb":     plan.ReturnedMB,
+	}).Info("Quota subtree released successfully")
 
-		// 3. Update quota usage
-		updateQuery := `UPDATE quotas SET used_mb = used_mb - $1, updated_at = NOW() WHERE id = $2`
-		_, err = tx.Exec(updateQuery, request.UsageMB, quotaID)
-		if err != nil {
-			return fmt.Errorf("failed to update quota usage: %w", err)
-		}
+	return plan, nil
+}
 
-		// 4. Record usage
+// releaseStepTx force-deallocates a quota's usage, returns its capacity to
+// its parent and soft-deletes it
+func (qs *QuotaService) releaseStepTx(tx *sql.Tx, userInfo *auth.UserInfo, rootQuotaID string, step models.QuotaReleaseStep, reason string) error {
+	// 1. Record a deallocation per resource with outstanding usage, under the
+	// same reason as the release audit entry
+	usageReason := reason
+	if usageReason == "" {
+		usageReason = "recursive release"
+	}
+	for _, usage := range step.ForcedUsage {
 		usageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
 		usageQuery := `
 			INSERT INTO quota_usage (id, quota_id, user_id, resource_id, usage_mb, operation, reason, created_at)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
 		`
-		_, err = tx.Exec(usageQuery, usageID, quotaID, userInfo.UserID, request.ResourceID,
-			request.UsageMB, models.OperationDeallocate, request.Reason)
+		_, err := tx.Exec(usageQuery, usageID, step.QuotaID, userInfo.UserID, usage.ResourceID,
+			usage.UsageMB, models.OperationDeallocate, usageReason)
 		if err != nil {
-			return fmt.Errorf("failed to record usage: %w", err)
+			return fmt.Errorf("failed to record usage for quota %s: %w", step.QuotaID, err)
 		}
+	}
 
-		// 5. Create audit log
-		err = qs.createAuditLogTx(tx, quotaID, "usage_deallocate", userInfo.UserID, nil, map[string]interface{}{
-			"resource_id": request.ResourceID,
-			"usage_mb":    request.UsageMB,
-			"reason":      request.Reason,
-		})
+	// 2. Return capacity to parent (if exists)
+	if step.ParentQuotaID != nil {
+		updateParentQuery := `UPDATE quotas SET allocated_mb = allocated_mb - $1, updated_at = NOW() WHERE id = $2`
+		_, err := tx.Exec(updateParentQuery, step.TotalMB, *step.ParentQuotaID)
 		if err != nil {
-			qs.logger.WithError(err).Warn("Failed to create audit log")
+			return fmt.Errorf("failed to update parent quota %s: %w", *step.ParentQuotaID, err)
 		}
+	}
+	if err := qs.releaseDimensionsTx(tx, step.QuotaID, step.ParentQuotaID); err != nil {
+		return err
+	}
 
-		return nil
-	})
-}
-
-// GetQuota retrieves a quota by ID
-func (qs *QuotaService) GetQuota(userInfo *auth.UserInfo, quotaID string) (*models.Quota, error) {
-	// Check read permission
-	hasPermission, err := qs.authClient.CheckPermission(userInfo, quotaID, []string{auth.QuotaPermissionRead})
+	// 3. Cancel active reservations and clear resource balances; the MB of
+	// both are part of the forced usage
+	cancelQuery := `
+		UPDATE quota_reservations SET status = $1, resolved_at = NOW()
+		WHERE quota_id = $2 AND status = $3
+	`
+	_, err := tx.Exec(cancelQuery, models.ReservationStatusCancelled, step.QuotaID, models.ReservationStatusActive)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check permissions: %w", err)
+		return fmt.Errorf("failed to cancel reservations of quota %s: %w", step.QuotaID, err)
 	}
-	if !hasPermission {
-		return nil, fmt.Errorf("insufficient permissions to view quota")
+	_, err = tx.Exec(`DELETE FROM quota_resource_usage WHERE quota_id = $1`, step.QuotaID)
+	if err != nil {
+		return fmt.Errorf("failed to clear resource usage of quota %s: %w", step.QuotaID, err)
 	}
 
-	query := `
-		SELECT id, name, description, type, total_mb, used_mb, allocated_mb, 
-		       parent_quota_id, level, path, owner_id, organization_id, team_id, 
-		       status, created_at, updated_at, deleted_at
-		FROM quotas 
-		WHERE id = $1 AND status != $2
+	// 4. Soft delete quota. Its children were released before it, so
+	// allocated_mb is already back to zero.
+	deleteQuery := `
+		UPDATE quotas SET used_mb = 0, subtree_used_mb = 0, reserved_mb = 0, status = $1, deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $2
 	`
-
-	quota := &models.Quota{}
-	row := qs.db.QueryRow(query, quotaID, models.QuotaStatusDeleted)
-
-	err = row.Scan(&quota.ID, &quota.Name, &quota.Description, &quota.Type,
-		&quota.TotalMB, &quota.UsedMB, &quota.AllocatedMB, &quota.ParentQuotaID,
-		&quota.Level, &quota.Path, &quota.OwnerID, &quota.OrganizationID, &quota.TeamID,
-		&quota.Status, &quota.CreatedAt, &quota.UpdatedAt, &quota.DeletedAt)
-
+	_, err = tx.Exec(deleteQuery, models.QuotaStatusDeleted, step.QuotaID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("quota not found")
-		}
-		return nil, fmt.Errorf("failed to get quota: %w", err)
+		return fmt.Errorf("failed to delete quota %s: %w", step.QuotaID, err)
 	}
 
-	// Calculate available MB
-	quota.AvailableMB = quota.TotalMB - quota.UsedMB - quota.AllocatedMB
+	// 5. Create audit log
+	err = qs.createAuditLogTx(tx, step.QuotaID, "release", userInfo.UserID, nil, map[string]interface{}{
+		"parent_quota_id": step.ParentQuotaID,
+		"returned_mb":     step.TotalMB,
+		"recursive":       true,
+		"root_quota_id":   rootQuotaID,
+		"forced_usage":    step.ForcedUsage,
+		"dimensions":      step.Dimensions,
+		"reason":          reason,
+	})
+	if err != nil {
+		qs.logger.WithError(err).Warn("Failed to create audit log")
+	}
 
-	return quota, nil
+	return nil
 }
 
-// Helper functions
-
-func (qs *QuotaService) getQuotaForUpdateTx(tx *sql.Tx, quotaID string) (*models.Quota, error) {
+// getSubtreeForUpdateTx locks and returns a quota and all of its live
+// descendants, deepest first. Rows are locked parent before child, like every
+// other path, and only reordered once they are held. The caller must already
+// hold the lock on root.
+func (qs *QuotaService) getSubtreeForUpdateTx(tx *sql.Tx, root *models.Quota) ([]*models.Quota, error) {
 	query := `
 		SELECT id, name, description, type, total_mb, used_mb, allocated_mb, 
 		       parent_quota_id, level, path, owner_id, organization_id, team_id, 
-		       status, created_at, updated_at, deleted_at
+		       status, created_at, updated_at, deleted_at, usage_mode, subtree_used_mb, overcommit_ratio,
+		       soft_limit_mb, warning_thresholds, reserved_mb
 		FROM quotas 
-		WHERE id = $1 AND status != $2
+		WHERE path LIKE $1 ESCAPE '\' AND status != $2
+		ORDER BY level, id
 		FOR UPDATE
 	`
 
-	quota := &models.Quota{}
-	row := tx.QueryRow(query, quotaID, models.QuotaStatusDeleted)
-
-	err := row.Scan(&quota.ID, &quota.Name, &quota.Description, &quota.Type,
-		&quota.TotalMB, &quota.UsedMB, &quota.AllocatedMB, &quota.ParentQuotaID,
-		&quota.Level, &quota.Path, &quota.OwnerID, &quota.OrganizationID, &quota.TeamID,
-		&quota.Status, &quota.CreatedAt, &quota.UpdatedAt, &quota.DeletedAt)
-
+	rows, err := tx.Query(query, escapeLikePattern(root.Path)+"/%", models.QuotaStatusDeleted)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("quota not found")
+		return nil, fmt.Errorf("failed to get quota subtree: %w", err)
+	}
+	defer rows.Close()
+
+	var quotas []*models.Quota
+	for rows.Next() {
+		quota := &models.Quota{}
+		err := rows.Scan(&quota.ID, &quota.Name, &quota.Description, &quota.Type,
+			&quota.TotalMB, &quota.UsedMB, &quota.AllocatedMB, &quota.ParentQuotaID,
+			&quota.Level, &quota.Path, &quota.OwnerID, &quota.OrganizationID, &quota.TeamID,
+			&quota.Status, &quota.CreatedAt, &quota.UpdatedAt, &quota.DeletedAt,
+			&quota.UsageMode, &quota.SubtreeUsedMB, &quota.OvercommitRatio,
+			&quota.SoftLimitMB, pq.Array(&quota.WarningThresholds), &quota.ReservedMB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan quota subtree: %w", err)
 		}
-		return nil, fmt.Errorf("failed to get quota: %w", err)
+		quota.ComputeCapacity()
+		quotas = append(quotas, quota)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get quota subtree: %w", err)
 	}
 
-	// Calculate available MB
-	quota.AvailableMB = quota.TotalMB - quota.UsedMB - quota.AllocatedMB
+	return deepestFirst(root, quotas), nil
+}
 
-	return quota, nil
+// deepestFirst orders a subtree so that every quota comes before its parent
+// and root comes last. descendants must be sorted parent before child.
+func deepestFirst(root *models.Quota, descendants []*models.Quota) []*models.Quota {
+	ordered := make([]*models.Quota, 0, len(descendants)+1)
+	for i := len(descendants) - 1; i >= 0; i-- {
+		ordered = append(ordered, descendants[i])
+	}
+	return append(ordered, root)
 }
 
-func (qs *QuotaService) validateAllocationRules(parentQuota *models.Quota, request *models.QuotaAllocateRequest) error {
-	// Organization quota can allocate to team quota
-	if parentQuota.Type == models.QuotaTypeOrganization && request.Type == models.QuotaTypeTeam {
-		return nil
+// outstandingUsageTx returns a quota's net usage per resource. Usage that the
+// quota_usage history does not account for is reported without a resource ID.
+// MB held by reservations are not part of the history and are left out.
+func (qs *QuotaService) outstandingUsageTx(tx *sql.Tx, quota *models.Quota) ([]models.QuotaResourceUsage, error) {
+	usage := []models.QuotaResourceUsage{}
+	usedMB := quota.UsedMB - quota.ReservedMB
+	if usedMB <= 0 {
+		return usage, nil
 	}
 
-	// Organization quota can allocate to organization quota
-	if parentQuota.Type == models.QuotaTypeOrganization && request.Type == models.QuotaTypeOrganization {
-		return nil
+	query := `
+		SELECT COALESCE(resource_id, ''),
+		       SUM(CASE WHEN operation = $2 THEN usage_mb ELSE -usage_mb END) AS net_mb
+		FROM quota_usage
+		WHERE quota_id = $1
+		GROUP BY COALESCE(resource_id, '')
+		HAVING SUM(CASE WHEN operation = $2 THEN usage_mb ELSE -usage_mb END) > 0
+		ORDER BY 1
+	`
+
+	rows, err := tx.Query(query, quota.ID, models.OperationAllocate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage for quota %s: %w", quota.ID, err)
 	}
+	defer rows.Close()
 
-	// Team quota can only allocate to same team
-	if parentQuota.Type == models.QuotaTypeTeam && request.Type == models.QuotaTypeTeam {
-		if parentQuota.TeamID == nil || request.TargetID != *parentQuota.TeamID {
-			return fmt.Errorf("team quota can only allocate to the same team")
+	var accounted int64
+	for rows.Next() {
+		var entry models.QuotaResourceUsage
+		if err := rows.Scan(&entry.ResourceID, &entry.UsageMB); err != nil {
+			return nil, fmt.Errorf("failed to scan usage for quota %s: %w", quota.ID, err)
 		}
-		return nil
+		// Never deallocate more than the quota actually has in use
+		if accounted+entry.UsageMB > usedMB {
+			entry.UsageMB = usedMB - accounted
+		}
+		if entry.UsageMB <= 0 {
+			break
+		}
+		accounted += entry.UsageMB
+		usage = append(usage, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get usage for quota %s: %w", quota.ID, err)
 	}
 
-	return fmt.Errorf("invalid allocation: %s quota cannot allocate to %s quota",
-		parentQuota.Type, request.Type)
+	if remaining := usedMB - accounted; remaining > 0 {
+		usage = append(usage, models.QuotaResourceUsage{UsageMB: remaining})
+	}
+
+	return usage, nil
 }
 
-func (qs *QuotaService) determineTeamID(parentQuota *models.Quota, request *models.QuotaAllocateRequest) *string {
-	if request.Type == models.QuotaTypeTeam {
-		return &request.TargetID
+// MoveQuota re-parents a quota, with its whole subtree, under another parent in
+// the same organization. The quota's total_mb moves from the old parent's
+// allocated_mb to the new parent's.
+func (qs *QuotaService) MoveQuota(userInfo *auth.UserInfo, quotaID string, request *models.QuotaMoveRequest) (*models.Quota, error) {
+	newParentQuotaID := request.NewParentQuotaID
+	if newParentQuotaID == "" {
+		return nil, fmt.Errorf("new_parent_quota_id is required")
+	}
+	if newParentQuotaID == quotaID {
+		return nil, fmt.Errorf("cannot move quota under itself")
 	}
-	return parentQuota.TeamID
-}
 
-// GetRuntimeUsage retrieves usage data grouped by runtime (resource_id)
-func (qs *QuotaService) GetRuntimeUsage(userInfo *auth.UserInfo, page, pageSize int) (*models.RuntimeUsageResponse, error) {
-	if page <= 0 {
-		page = 1
+	chain, err := qs.getQuotaChain(quotaID)
+	if err != nil {
+		return nil, err
 	}
-	if pageSize <= 0 || pageSize > 100 {
-		pageSize = 20
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("cannot move a root quota")
+	}
+	oldParentQuotaID := chain[len(chain)-2]
+	quotaPath := "/" + strings.Join(chain, "/")
+	if oldParentQuotaID == newParentQuotaID {
+		return nil, fmt.Errorf("cannot move quota: already under %s", newParentQuotaID)
 	}
 
-	offset := (page - 1) * pageSize
+	// Moving takes capacity from one parent and gives it to another, so it
+	// needs admin on both
+	if _, err := qs.authorize(userInfo, oldParentQuotaID, []string{auth.QuotaPermissionAdmin}, "move quota"); err != nil {
+		return nil, err
+	}
+	if _, err := qs.authorize(userInfo, newParentQuotaID, []string{auth.QuotaPermissionAdmin}, "move quota"); err != nil {
+		return nil, err
+	}
 
-	// Get runtime usage data with quota information
-	query := `
-		WITH runtime_usage AS (
-			SELECT 
-				qu.resource_id,
-				SUM(CASE WHEN qu.operation = 'allocate' THEN qu.usage_mb ELSE -qu.usage_mb END) as total_usage_mb,
-				COUNT(DISTINCT qu.quota_id) as quota_count,
-				MAX(qu.created_at) as last_activity,
-				ARRAY_AGG(DISTINCT q.id) as quota_ids,
-				ARRAY_AGG(DISTINCT q.name) as quota_names
-			FROM quota_usage qu
-			JOIN quotas q ON qu.quota_id = q.id
-			WHERE q.organization_id = $1 
-				AND q.status = 'active'
-				AND qu.resource_id IS NOT NULL
-			GROUP BY qu.resource_id
-			HAVING SUM(CASE WHEN qu.operation = 'allocate' THEN qu.usage_mb ELSE -qu.usage_mb END) > 0
-		)
-		SELECT 
-			resource_id,
+	newParentChain, err := qs.getQuotaChain(newParentQuotaID)
+	if err != nil {
+		return nil, err
+	}
+
+	var quota *models.Quota
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock both parents' chains, the quota and its subtree in one pass,
+		// parent before child, the same order allocation, resize and usage
+		// changes take their locks. The new parent can sit deeper than parts
+		// of the subtree, so the subtree cannot be locked afterwards. The
+		// ancestors are needed for rollup accounting.
+		lockIDs := append([]string{}, chain...)
+		for _, id := range newParentChain {
+			if !containsString(lockIDs, id) {
+				lockIDs = append(lockIDs, id)
+			}
+		}
+		if err := qs.lockQuotasWithSubtreeTx(tx, lockIDs, quotaPath); err != nil {
+			return err
+		}
+		locked := make(map[string]*models.Quota, len(lockIDs))
+		for _, id := range lockIDs {
+			q, err := qs.getQuotaForUpdateTx(tx, id)
+			if err != nil {
+				return fmt.Errorf("failed to get quota %s: %w", id, err)
+			}
+			locked[id] = q
+		}
+		quota = locked[quotaID]
+		oldParent := locked[oldParentQuotaID]
+		newParent := locked[newParentQuotaID]
+
+		if quota.Path != quotaPath {
+			return fmt.Errorf("quota hierarchy changed during move, please retry")
+		}
+
+		// 2. Validate the new parent
+		if newParent.OrganizationID != quota.OrganizationID {
+			return fmt.Errorf("cannot move quota to a parent in another organization")
+		}
+		if strings.HasPrefix(newParent.Path+"/", quota.Path+"/") {
+			return fmt.Errorf("cannot move quota under its own descendant")
+		}
+		if newParent.Status == models.QuotaStatusSuspended {
+			return fmt.Errorf("%w: %s", ErrQuotaSuspended, newParentQuotaID)
+		}
+		if newParent.AllocatableMB < quota.TotalMB {
+			return fmt.Errorf("insufficient quota: new parent available %d MB, requested %d MB",
+				newParent.AllocatableMB, quota.TotalMB)
+		}
+
+		targetID := ""
+		if quota.TeamID != nil {
+			targetID = *quota.TeamID
+		}
+		err := qs.validateAllocationRules(newParent, &models.QuotaAllocateRequest{
+			Type:     quota.Type,
+			TargetID: targetID,
+		})
+		if err != nil {
+			return err
+		}
+
+		// 3. Load the subtree, already locked in step 1
+		subtree, err := qs.getSubtreeForUpdateTx(tx, quota)
+		if err != nil {
+			return err
+		}
+		if err := validateMoveOvercommit(subtree, newParent.UsageMode); err != nil {
+			return err
+		}
+
+		// 4. Transfer capacity between parents
+		updateParentQuery := `UPDATE quotas SET allocated_mb = allocated_mb + $1, updated_at = NOW() WHERE id = $2`
+		if _, err := tx.Exec(updateParentQuery, -quota.TotalMB, oldParentQuotaID); err != nil {
+			return fmt.Errorf("failed to update old parent quota: %w", err)
+		}
+		if _, err := tx.Exec(updateParentQuery, quota.TotalMB, newParentQuotaID); err != nil {
+			return fmt.Errorf("failed to update new parent quota: %w", err)
+		}
+		if err := qs.moveDimensionsTx(tx, quotaID, oldParentQuotaID, newParentQuotaID); err != nil {
+			return err
+		}
+
+		// 5. Re-parent the quota and rewrite path and level for the subtree
+		oldPath := quota.Path
+		newPath := newParent.Path + "/" + quotaID
+		levelDelta := newParent.Level + 1 - quota.Level
+
+		moveQuery := `
+			UPDATE quotas SET parent_quota_id = $1, level = $2, path = $3, updated_at = NOW()
+			WHERE id = $4
+		`
+		if _, err := tx.Exec(moveQuery, newParentQuotaID, newParent.Level+1, newPath, quotaID); err != nil {
+			return fmt.Errorf("failed to move quota: %w", err)
+		}
+
+		descendantsQuery := `
+			UPDATE quotas SET path = $1 || SUBSTRING(path FROM $2), level = level + $3, updated_at = NOW()
+			WHERE path LIKE $4 ESCAPE '\'
+		`
+		_, err = tx.Exec(descendantsQuery, newPath, len(oldPath)+1, levelDelta, escapeLikePattern(oldPath)+"/%")
+		if err != nil {
+			return fmt.Errorf("failed to update descendant paths: %w", err)
+		}
+
+		// 6. Move the subtree's usage from the old ancestors' rollups to the
+		// new ones. A subtree moving into a tree with another usage mode
+		// adopts that mode.
+		var subtreeUsedMB int64
+		for _, q := range subtree {
+			subtreeUsedMB += q.UsedMB
+		}
+		if quota.UsageMode == models.UsageModeRollup {
+			if err := qs.applyRollupDeltaTx(tx, chain[:len(chain)-1], -subtreeUsedMB); err != nil {
+				return err
+			}
+			if err := qs.applyDimensionRollupTx(tx, quotaID, chain[:len(chain)-1], -1); err != nil {
+				return err
+			}
+		}
+		if newParent.UsageMode != quota.UsageMode {
+			if err := qs.setSubtreeUsageModeTx(tx, quotaID, newPath, newParent.UsageMode); err != nil {
+				return err
+			}
+			quota.UsageMode = newParent.UsageMode
+		}
+		if newParent.UsageMode == models.UsageModeRollup {
+			// Overcommitted new ancestors must physically hold the subtree's
+			// usage. Shared ancestors were already debited above.
+			if err := qs.checkPhysicalCapacityOfTx(tx, newParentChain, subtreeUsedMB); err != nil {
+				return err
+			}
+			if err := qs.applyRollupDeltaTx(tx, newParentChain, subtreeUsedMB); err != nil {
+				return err
+			}
+			if err := qs.applyDimensionRollupTx(tx, quotaID, newParentChain, 1); err != nil {
+				return err
+			}
+		}
+
+		// 7. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "move", userInfo.UserID, nil, map[string]interface{}{
+			"old_parent_quota_id":            oldParentQuotaID,
+			"new_parent_quota_id":            newParentQuotaID,
+			"old_path":                       oldPath,
+			"new_path":                       newPath,
+			"old_level":                      quota.Level,
+			"new_level":                      newParent.Level + 1,
+			"moved_mb":                       quota.TotalMB,
+			"descendant_count":               len(subtree) - 1,
+			"old_parent_before_allocated_mb": oldParent.AllocatedMB,
+			"new_parent_before_allocated_mb": newParent.AllocatedMB,
+			"reason":                         request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		quota.ParentQuotaID = &newParentQuotaID
+		quota.Level = newParent.Level + 1
+		quota.Path = newPath
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"quota_id":            quotaID,
+		"old_parent_quota_id": oldParentQuotaID,
+		"new_parent_quota_id": newParentQuotaID,
+		"moved_mb":            quota.TotalMB,
+	}).Info("Quota moved successfully")
+
+	return quota, nil
+}
+
+// ResizeQuota changes a quota's total_mb and/or overcommit_ratio. For a child
+// quota the difference in total_mb is taken from (or returned to) the parent's
+// allocated_mb.
+func (qs *QuotaService) ResizeQuota(userInfo *auth.UserInfo, quotaID string, request *models.QuotaResizeRequest) (*models.Quota, error) {
+	if request.TotalMB < 0 {
+		return nil, fmt.Errorf("total_mb must be greater than 0")
+	}
+	if request.TotalMB == 0 && request.OvercommitRatio == nil && len(request.Dimensions) == 0 {
+		return nil, fmt.Errorf("total_mb, overcommit_ratio or dimensions is required")
+	}
+	if err := validateDimensionAmounts(request.Dimensions, 0); err != nil {
+		return nil, err
+	}
+
+	// Resizing a child moves capacity in or out of its parent, so it needs
+	// admin on the parent; a root quota needs admin on itself
+	chain, err := qs.getQuotaChain(quotaID)
+	if err != nil {
+		return nil, err
+	}
+	var parentQuotaID string
+	authzQuotaID := quotaID
+	if len(chain) > 1 {
+		parentQuotaID = chain[len(chain)-2]
+		authzQuotaID = parentQuotaID
+	}
+	if _, err := qs.authorize(userInfo, authzQuotaID, []string{auth.QuotaPermissionAdmin}, "resize quota"); err != nil {
+		return nil, err
+	}
+
+	var quota *models.Quota
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock parent before child, matching AllocateQuota's lock order
+		var parentQuota *models.Quota
+		if parentQuotaID != "" {
+			parentQuota, err = qs.getQuotaForUpdateTx(tx, parentQuotaID)
+			if err != nil {
+				return fmt.Errorf("failed to get parent quota: %w", err)
+			}
+		}
+
+		// 2. Get quota with lock
+		quota, err = qs.getQuotaForUpdateTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		if (quota.ParentQuotaID == nil && parentQuota != nil) ||
+			(quota.ParentQuotaID != nil && *quota.ParentQuotaID != parentQuotaID) {
+			return fmt.Errorf("quota hierarchy changed during resize, please retry")
+		}
+
+		oldTotalMB := quota.TotalMB
+		newTotalMB := request.TotalMB
+		if newTotalMB == 0 {
+			newTotalMB = oldTotalMB
+		}
+		delta := newTotalMB - oldTotalMB
+
+		oldRatio := quota.OvercommitRatio
+		newRatio := oldRatio
+		if request.OvercommitRatio != nil {
+			newRatio, err = validateOvercommitRatio(*request.OvercommitRatio, quota.UsageMode)
+			if err != nil {
+				return err
+			}
+		}
+
+		// 3. Validate the new size against what the quota has handed out
+		if quota.UsedMB+quota.AllocatedMB > models.OvercommitLimitMB(newTotalMB, newRatio) {
+			return fmt.Errorf("cannot shrink quota below used (%d MB) + allocated (%d MB)",
+				quota.UsedMB, quota.AllocatedMB)
+		}
+		if parentQuota != nil && delta > parentQuota.AllocatableMB {
+			return fmt.Errorf("insufficient quota: parent available %d MB, requested %d MB",
+				parentQuota.AllocatableMB, delta)
+		}
+
+		// 4. Update quota total_mb and overcommit_ratio
+		updateQuery := `UPDATE quotas SET total_mb = $1, overcommit_ratio = $2, updated_at = NOW() WHERE id = $3`
+		_, err = tx.Exec(updateQuery, newTotalMB, newRatio, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to resize quota: %w", err)
+		}
+
+		details := map[string]interface{}{
+			"before_total_mb":         oldTotalMB,
+			"after_total_mb":          newTotalMB,
+			"delta_mb":                delta,
+			"before_overcommit_ratio": oldRatio,
+			"after_overcommit_ratio":  newRatio,
+			"reason":                  request.Reason,
+		}
+
+		// 5. Resize dimensions
+		if len(request.Dimensions) > 0 {
+			details["dimensions"], err = qs.resizeDimensionsTx(tx, quota, parentQuota, request.Dimensions)
+			if err != nil {
+				return err
+			}
+		}
+
+		// 6. Adjust parent quota allocated_mb
+		if parentQuota != nil {
+			updateParentQuery := `UPDATE quotas SET allocated_mb = allocated_mb + $1, updated_at = NOW() WHERE id = $2`
+			_, err = tx.Exec(updateParentQuery, delta, parentQuotaID)
+			if err != nil {
+				return fmt.Errorf("failed to update parent quota: %w", err)
+			}
+
+			details["parent_quota_id"] = parentQuotaID
+			details["parent_before_allocated_mb"] = parentQuota.AllocatedMB
+			details["parent_after_allocated_mb"] = parentQuota.AllocatedMB + delta
+		}
+
+		// 7. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "resize", userInfo.UserID, nil, details)
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		quota.TotalMB = newTotalMB
+		quota.OvercommitRatio = newRatio
+		quota.ComputeCapacity()
+		dimensions, err := loadDimensions(tx, []string{quotaID})
+		if err != nil {
+			return err
+		}
+		quota.Dimensions = dimensions[quotaID]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"quota_id":         quotaID,
+		"parent_quota_id":  parentQuotaID,
+		"total_mb":         quota.TotalMB,
+		"overcommit_ratio": quota.OvercommitRatio,
+	}).Info("Quota resized successfully")
+
+	// Shrinking an overcommitted quota can leave real usage above its capacity
+	if quota.PhysicalAvailableMB != nil && *quota.PhysicalAvailableMB < 0 {
+		qs.logger.WithFields(logrus.Fields{
+			"quota_id":        quotaID,
+			"total_mb":        quota.TotalMB,
+			"subtree_used_mb": quota.SubtreeUsedMB,
+		}).Warn("Quota usage exceeds physical capacity")
+
+		qs.createAuditLog(quotaID, "overcommit_exceeded", userInfo.UserID, nil, map[string]interface{}{
+			"total_mb":        quota.TotalMB,
+			"subtree_used_mb": quota.SubtreeUsedMB,
+		})
+	}
+
+	return quota, nil
+}
+
+// SuspendQuota suspends a quota, and optionally all of its descendants.
+// Suspended quotas reject usage allocation and sub-allocation.
+func (qs *QuotaService) SuspendQuota(userInfo *auth.UserInfo, quotaID string, request *models.QuotaStatusRequest) (*models.QuotaStatusResponse, error) {
+	return qs.changeQuotaStatus(userInfo, quotaID, request, models.QuotaStatusActive, models.QuotaStatusSuspended, "suspend")
+}
+
+// ResumeQuota reactivates a suspended quota, and optionally all of its descendants
+func (qs *QuotaService) ResumeQuota(userInfo *auth.UserInfo, quotaID string, request *models.QuotaStatusRequest) (*models.QuotaStatusResponse, error) {
+	return qs.changeQuotaStatus(userInfo, quotaID, request, models.QuotaStatusSuspended, models.QuotaStatusActive, "resume")
+}
+
+// changeQuotaStatus moves a quota (and with cascade, every descendant on its
+// path) from one status to another. Quotas already in the target status are
+// left untouched.
+func (qs *QuotaService) changeQuotaStatus(userInfo *auth.UserInfo, quotaID string, request *models.QuotaStatusRequest, fromStatus, toStatus, action string) (*models.QuotaStatusResponse, error) {
+	// Check admin permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionAdmin}, action+" quota"); err != nil {
+		return nil, err
+	}
+
+	response := &models.QuotaStatusResponse{
+		QuotaID:          quotaID,
+		Status:           toStatus,
+		Cascade:          request.Cascade,
+		AffectedQuotaIDs: []string{},
+	}
+
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Get quota with lock
+		quota, err := qs.getQuotaForUpdateTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		if quota.Status != fromStatus && !request.Cascade {
+			return fmt.Errorf("cannot %s quota in status %s", action, quota.Status)
+		}
+
+		// 2. Update the quota, plus its descendants when cascading
+		updateQuery := `UPDATE quotas SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3 RETURNING id`
+		args := []interface{}{toStatus, quotaID, fromStatus}
+		if request.Cascade {
+			updateQuery = `
+				UPDATE quotas SET status = $1, updated_at = NOW()
+				WHERE (id = $2 OR path LIKE $4 ESCAPE '\') AND status = $3
+				RETURNING id
+			`
+			args = append(args, escapeLikePattern(quota.Path)+"/%")
+		}
+
+		rows, err := tx.Query(updateQuery, args...)
+		if err != nil {
+			return fmt.Errorf("failed to %s quota: %w", action, err)
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to %s quota: %w", action, err)
+			}
+			response.AffectedQuotaIDs = append(response.AffectedQuotaIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to %s quota: %w", action, err)
+		}
+
+		// 3. Create audit logs: one for the requested quota and one per
+		// descendant reached by the cascade
+		err = qs.createAuditLogTx(tx, quotaID, action, userInfo.UserID, nil, map[string]interface{}{
+			"previous_status":    quota.Status,
+			"status":             toStatus,
+			"cascade":            request.Cascade,
+			"affected_quota_ids": response.AffectedQuotaIDs,
+			"reason":             request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+		for _, id := range response.AffectedQuotaIDs {
+			if id == quotaID {
+				continue
+			}
+			err = qs.createAuditLogTx(tx, id, action, userInfo.UserID, nil, map[string]interface{}{
+				"status":        toStatus,
+				"cascaded_from": quotaID,
+				"reason":        request.Reason,
+			})
+			if err != nil {
+				qs.logger.WithError(err).Warn("Failed to create audit log")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"quota_id":       quotaID,
+		"status":         toStatus,
+		"cascade":        request.Cascade,
+		"affected_count": len(response.AffectedQuotaIDs),
+	}).Infof("Quota %s completed", action)
+
+	return response, nil
+}
+
+// GrantPermission grants (or explicitly denies) quota permissions to a user
+func (qs *QuotaService) GrantPermission(userInfo *auth.UserInfo, quotaID string, request *models.QuotaGrantPermissionRequest) error {
+	effect := request.Effect
+	if effect == "" {
+		effect = auth.PermissionEffectAllow
+	}
+	if effect != auth.PermissionEffectAllow && effect != auth.PermissionEffectDeny {
+		return fmt.Errorf("invalid permission effect: %s", effect)
+	}
+	if request.ExpiresAt != nil && *request.ExpiresAt <= time.Now().UnixMilli() {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+
+	// Managing permissions requires admin, and owner to hand out owner
+	if err := qs.authorizePermissionChange(userInfo, quotaID, request.Permissions); err != nil {
+		return err
+	}
+
+	var err error
+	if effect == auth.PermissionEffectDeny {
+		err = qs.authorizer.DenyPermission(userInfo, request.TargetUserID, quotaID, request.Permissions, request.ExpiresAt)
+	} else {
+		err = qs.authorizer.GrantPermission(userInfo, request.TargetUserID, quotaID, request.Permissions, request.ExpiresAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	details := map[string]interface{}{
+		"permissions": request.Permissions,
+		"effect":      effect,
+	}
+	if request.ExpiresAt != nil {
+		details["expires_at"] = *request.ExpiresAt
+	}
+	qs.createAuditLog(quotaID, "permission_grant", userInfo.UserID, &request.TargetUserID, details)
+
+	qs.logger.WithFields(logrus.Fields{
+		"admin_user_id":  userInfo.UserID,
+		"target_user_id": request.TargetUserID,
+		"quota_id":       quotaID,
+		"permissions":    request.Permissions,
+		"effect":         effect,
+		"expires_at":     request.ExpiresAt,
+	}).Info("Quota permission updated successfully")
+
+	return nil
+}
+
+// RevokePermission removes a user's grants or denies on a quota
+func (qs *QuotaService) RevokePermission(userInfo *auth.UserInfo, quotaID string, request *models.QuotaRevokePermissionRequest) error {
+	if err := qs.authorizePermissionChange(userInfo, quotaID, request.Permissions); err != nil {
+		return err
+	}
+
+	err := qs.authorizer.RevokePermission(userInfo, request.TargetUserID, quotaID, request.Permissions)
+	if err != nil {
+		return err
+	}
+
+	qs.createAuditLog(quotaID, "permission_revoke", userInfo.UserID, &request.TargetUserID, map[string]interface{}{
+		"permissions": request.Permissions,
+	})
+
+	qs.logger.WithFields(logrus.Fields{
+		"admin_user_id":  userInfo.UserID,
+		"target_user_id": request.TargetUserID,
+		"quota_id":       quotaID,
+		"permissions":    request.Permissions,
+	}).Info("Quota permission revoked successfully")
+
+	return nil
+}
+
+// ListPermissions lists the grants and denies held directly on a quota
+func (qs *QuotaService) ListPermissions(userInfo *auth.UserInfo, quotaID string) (*models.QuotaPermissionListResponse, error) {
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionAdmin}, "list quota permissions"); err != nil {
+		return nil, err
+	}
+
+	entries, err := qs.authorizer.ListPermissions(userInfo, quotaID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make([]models.QuotaPermissionEntry, 0, len(entries))
+	for _, entry := range entries {
+		permissions = append(permissions, models.QuotaPermissionEntry{
+			UserID:     entry.UserID,
+			Permission: entry.Permission,
+			Effect:     entry.Effect,
+			GrantedBy:  entry.GrantedBy,
+			ExpiresAt:  entry.ExpiresAt,
+		})
+	}
+
+	return &models.QuotaPermissionListResponse{
+		QuotaID:     quotaID,
+		Permissions: permissions,
+	}, nil
+}
+
+// authorizePermissionChange requires admin on the quota, or owner when the
+// change involves the owner permission
+func (qs *QuotaService) authorizePermissionChange(userInfo *auth.UserInfo, quotaID string, permissions []string) error {
+	required := auth.QuotaPermissionAdmin
+	if containsString(permissions, auth.QuotaPermissionOwner) {
+		required = auth.QuotaPermissionOwner
+	}
+	_, err := qs.authorize(userInfo, quotaID, []string{required}, "manage quota permissions")
+	return err
+}
+
+// AllocateUsage allocates usage to a quota. Crossing a warning threshold or
+// the soft limit does not fail the call; it is reported in the response.
+func (qs *QuotaService) AllocateUsage(userInfo *auth.UserInfo, quotaID string, request *models.QuotaUsageRequest) (*models.QuotaUsageResponse, error) {
+	if err := validateUsageRequest(request); err != nil {
+		return nil, err
+	}
+	requestHash, err := qs.idempotencyHash(userInfo, models.OperationAllocate, request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check read permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "use quota"); err != nil {
+		return nil, err
+	}
+
+	var response *models.QuotaUsageResponse
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
+		var err error
+		response, err = qs.allocateUsageTx(tx, userInfo, quotaID, request, requestHash)
+		return err
+	})
+
+	var capacityErr *physicalCapacityError
+	if errors.As(err, &capacityErr) {
+		qs.reportOvercommitExhausted(userInfo, quotaID, capacityErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logUsageResult(quotaID, request, response)
+	return response, nil
+}
+
+// allocateUsageTx allocates usage to a quota within tx. A request whose
+// idempotency key was seen before returns the stored result instead.
+func (qs *QuotaService) allocateUsageTx(tx *sql.Tx, userInfo *auth.UserInfo, quotaID string, request *models.QuotaUsageRequest, requestHash string) (*models.QuotaUsageResponse, error) {
+	response := &models.QuotaUsageResponse{QuotaID: quotaID}
+
+	// 1. Get quota with lock (and its ancestors in rollup mode)
+	quota, err := qs.lockQuotaForUsageTx(tx, quotaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	// A retry of an earlier request returns its original result
+	if replayed, err := qs.replayIdempotentRequestTx(tx, quotaID, request, requestHash, response); err != nil || replayed {
+		return response, err
+	}
+
+	if quota.Status == models.QuotaStatusSuspended {
+		return nil, fmt.Errorf("%w: %s", ErrQuotaSuspended, quotaID)
+	}
+
+	// 2. Check available capacity, then the real consumption of any
+	// overcommitted quota on the path
+	if quota.AllocatableMB < request.UsageMB {
+		return nil, fmt.Errorf("insufficient quota: available %d MB, requested %d MB",
+			quota.AllocatableMB, request.UsageMB)
+	}
+	if err := qs.checkPhysicalCapacityTx(tx, quota, request.UsageMB); err != nil {
+		return nil, err
+	}
+
+	// 3. Update quota usage (and rollups)
+	beforeMB := quota.UsedMB + quota.AllocatedMB
+	err = qs.applyUsageDeltaTx(tx, quota, request.UsageMB)
+	if err != nil {
+		return nil, err
+	}
+	if err := qs.applyResourceUsageDeltaTx(tx, quotaID, request.ResourceID, request.UsageMB); err != nil {
+		return nil, err
+	}
+	response.UsedMB = quota.UsedMB
+	response.AvailableMB = quota.AvailableMB
+	response.Warnings = usageWarnings(quota, beforeMB, quota.UsedMB+quota.AllocatedMB)
+	response.Warning = len(response.Warnings) > 0
+
+	// Every dimension must fit too, or the whole call is rolled back
+	response.Dimensions, err = qs.applyDimensionUsageTx(tx, quota, request.Dimensions, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// 4. Record usage
+	usageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
+	usageQuery := `
+		INSERT INTO quota_usage (id, quota_id, user_id, resource_id, usage_mb, operation, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+	`
+	_, err = tx.Exec(usageQuery, usageID, quotaID, userInfo.UserID, request.ResourceID,
+		request.UsageMB, models.OperationAllocate, request.Reason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record usage: %w", err)
+	}
+	if err := qs.recordUsageDimensionsTx(tx, usageID, request.Dimensions); err != nil {
+		return nil, err
+	}
+	if request.IdempotencyKey != "" {
+		err = qs.saveIdempotencyKeyTx(tx, quotaID, request.IdempotencyKey, models.OperationAllocate, requestHash, usageID, response)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 5. Create audit log
+	err = qs.createAuditLogTx(tx, quotaID, "usage_allocate", userInfo.UserID, nil, map[string]interface{}{
+		"resource_id":     request.ResourceID,
+		"usage_mb":        request.UsageMB,
+		"dimensions":      request.Dimensions,
+		"idempotency_key": request.IdempotencyKey,
+		"reason":          request.Reason,
+	})
+	if err != nil {
+		qs.logger.WithError(err).Warn("Failed to create audit log")
+	}
+
+	// 6. Record crossed warning limits
+	for _, warning := range response.Warnings {
+		err = qs.createAuditLogTx(tx, quotaID, "threshold_crossed", userInfo.UserID, nil, map[string]interface{}{
+			"type":              warning.Type,
+			"threshold_percent": warning.ThresholdPercent,
+			"limit_mb":          warning.LimitMB,
+			"before_mb":         warning.BeforeMB,
+			"after_mb":          warning.AfterMB,
+			"resource_id":       request.ResourceID,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+	}
+
+	return response, nil
+}
+
+// DeallocateUsage deallocates usage from a quota. A retried request with the
+// same idempotency key reports replayed=true and changes nothing.
+func (qs *QuotaService) DeallocateUsage(userInfo *auth.UserInfo, quotaID string, request *models.QuotaUsageRequest) (replayed bool, err error) {
+	if err := validateUsageRequest(request); err != nil {
+		return false, err
+	}
+	requestHash, err := qs.idempotencyHash(userInfo, models.OperationDeallocate, request)
+	if err != nil {
+		return false, err
+	}
+
+	// Check read permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "deallocate quota usage"); err != nil {
+		return false, err
+	}
+
+	err = qs.db.WithTransaction(func(tx *sql.Tx) error {
+		response, err := qs.deallocateUsageTx(tx, userInfo, quotaID, request, requestHash)
+		if err != nil {
+			return err
+		}
+		replayed = response.Replayed
+		return nil
+	})
+	return replayed, err
+}
+
+// deallocateUsageTx deallocates usage from a quota within tx. A request whose
+// idempotency key was seen before returns the stored result instead.
+func (qs *QuotaService) deallocateUsageTx(tx *sql.Tx, userInfo *auth.UserInfo, quotaID string, request *models.QuotaUsageRequest, requestHash string) (*models.QuotaUsageResponse, error) {
+	response := &models.QuotaUsageResponse{QuotaID: quotaID, Warnings: []models.QuotaUsageWarning{}}
+
+	// 1. Get quota with lock (and its ancestors in rollup mode)
+	quota, err := qs.lockQuotaForUsageTx(tx, quotaID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	// A retry of an earlier request has already been applied
+	if replayed, err := qs.replayIdempotentRequestTx(tx, quotaID, request, requestHash, response); err != nil || replayed {
+		return response, err
+	}
+
+	// 2. Check if enough usage to deallocate; reserved MB are released
+	// through their reservation only
+	if inUseMB := quota.UsedMB - quota.ReservedMB; inUseMB < request.UsageMB {
+		return nil, fmt.Errorf("cannot deallocate %d MB, only %d MB in use", request.UsageMB, inUseMB)
+	}
+	if err := qs.checkResourceDeallocationTx(tx, quotaID, request.ResourceID, request.UsageMB); err != nil {
+		return nil, err
+	}
+
+	// 3. Update quota usage (and rollups) and the resource balance
+	err = qs.applyUsageDeltaTx(tx, quota, -request.UsageMB)
+	if err != nil {
+		return nil, err
+	}
+	if err := qs.applyResourceUsageDeltaTx(tx, quotaID, request.ResourceID, -request.UsageMB); err != nil {
+		return nil, err
+	}
+	response.UsedMB = quota.UsedMB
+	response.AvailableMB = quota.AvailableMB
+	response.Dimensions, err = qs.applyDimensionUsageTx(tx, quota, request.Dimensions, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	// 4. Record usage
+	usageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
+	usageQuery := `
+		INSERT INTO quota_usage (id, quota_id, user_id, resource_id, usage_mb, operation, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+	`
+	_, err = tx.Exec(usageQuery, usageID, quotaID, userInfo.UserID, request.ResourceID,
+		request.UsageMB, models.OperationDeallocate, request.Reason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record usage: %w", err)
+	}
+	if err := qs.recordUsageDimensionsTx(tx, usageID, request.Dimensions); err != nil {
+		return nil, err
+	}
+	if request.IdempotencyKey != "" {
+		err = qs.saveIdempotencyKeyTx(tx, quotaID, request.IdempotencyKey, models.OperationDeallocate, requestHash, usageID, response)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 5. Create audit log
+	err = qs.createAuditLogTx(tx, quotaID, "usage_deallocate", userInfo.UserID, nil, map[string]interface{}{
+		"resource_id":     request.ResourceID,
+		"usage_mb":        request.UsageMB,
+		"dimensions":      request.Dimensions,
+		"idempotency_key": request.IdempotencyKey,
+		"reason":          request.Reason,
+	})
+	if err != nil {
+		qs.logger.WithError(err).Warn("Failed to create audit log")
+	}
+
+	return response, nil
+}
+
+// logUsageResult logs replayed allocations and crossed warning limits
+func (qs *QuotaService) logUsageResult(quotaID string, request *models.QuotaUsageRequest, response *models.QuotaUsageResponse) {
+	if response.Replayed {
+		qs.logger.WithFields(logrus.Fields{
+			"quota_id":        quotaID,
+			"idempotency_key": request.IdempotencyKey,
+		}).Info("Usage request replayed for idempotency key")
+		return
+	}
+
+	if response.Warning {
+		qs.logger.WithFields(logrus.Fields{
+			"quota_id":    quotaID,
+			"resource_id": request.ResourceID,
+			"warnings":    response.Warnings,
+		}).Warn("Quota usage crossed warning limits")
+	}
+}
+
+// GetQuota retrieves a quota by ID
+func (qs *QuotaService) GetQuota(userInfo *auth.UserInfo, quotaID string) (*models.Quota, error) {
+	// Check read permission
+	decision, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "view quota")
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, name, description, type, total_mb, used_mb, allocated_mb, 
+		       parent_quota_id, level, path, owner_id, organization_id, team_id, 
+		       status, created_at, updated_at, deleted_at, usage_mode, subtree_used_mb, overcommit_ratio,
+		       soft_limit_mb, warning_thresholds, reserved_mb
+		FROM quotas 
+		WHERE id = $1 AND status != $2
+	`
+
+	quota := &models.Quota{}
+	row := qs.db.QueryRow(query, quotaID, models.QuotaStatusDeleted)
+
+	err = row.Scan(&quota.ID, &quota.Name, &quota.Description, &quota.Type,
+		&quota.TotalMB, &quota.UsedMB, &quota.AllocatedMB, &quota.ParentQuotaID,
+		&quota.Level, &quota.Path, &quota.OwnerID, &quota.OrganizationID, &quota.TeamID,
+		&quota.Status, &quota.CreatedAt, &quota.UpdatedAt, &quota.DeletedAt,
+		&quota.UsageMode, &quota.SubtreeUsedMB, &quota.OvercommitRatio,
+		&quota.SoftLimitMB, pq.Array(&quota.WarningThresholds), &quota.ReservedMB)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("quota not found")
+		}
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	// Calculate available MB
+	quota.ComputeCapacity()
+	quota.Access = decision
+
+	dimensions, err := loadDimensions(qs.db, []string{quotaID})
+	if err != nil {
+		return nil, err
+	}
+	quota.Dimensions = dimensions[quotaID]
+
+	return quota, nil
+}
+
+// Helper functions
+
+// authorize checks permissions on a quota according to the enforcement mode.
+// In audit-only mode failed checks are logged and audited but allowed.
+func (qs *QuotaService) authorize(userInfo *auth.UserInfo, quotaID string, permissions []string, action string) (*models.PermissionDecision, error) {
+	if qs.authzMode == AuthzModeOff {
+		return nil, nil
+	}
+
+	decision, err := qs.checkPermission(userInfo, quotaID, permissions)
+	if err == nil && decision.Allowed {
+		return decision, nil
+	}
+
+	if qs.authzMode == AuthzModeAuditOnly {
+		logEntry := qs.logger.WithFields(logrus.Fields{
+			"user_id":     userInfo.UserID,
+			"quota_id":    quotaID,
+			"permissions": permissions,
+			"action":      action,
+		})
+		if err != nil {
+			logEntry = logEntry.WithError(err)
+		}
+		logEntry.Warn("Permission check failed in audit-only mode, allowing request")
+
+		details := map[string]interface{}{
+			"action":      action,
+			"permissions": permissions,
+			"authz_mode":  qs.authzMode,
+		}
+		if err != nil {
+			details["error"] = err.Error()
+		} else if len(decision.DeniedBy) > 0 {
+			details["denied_by"] = decision.DeniedBy
+		}
+		qs.createAuditLog(quotaID, "authz_denied", userInfo.UserID, nil, details)
+		return decision, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to check permissions: %w", err)
+	}
+	return nil, fmt.Errorf("insufficient permissions to %s", action)
+}
+
+// checkPermission resolves permissions on a quota by walking its path from the
+// quota up to the root. The nearest explicit grant or deny wins, so a deny on
+// a child overrides a grant on any of its ancestors.
+func (qs *QuotaService) checkPermission(userInfo *auth.UserInfo, quotaID string, permissions []string) (*models.PermissionDecision, error) {
+	chain, err := qs.getQuotaChain(quotaID)
+	if err != nil {
+		return nil, err
+	}
+
+	decision := &models.PermissionDecision{
+		GrantedVia: make(map[string]string),
+		DeniedBy:   make(map[string]string),
+	}
+
+	pending := make([]string, 0, len(permissions))
+	for _, perm := range permissions {
+		if !containsString(pending, perm) {
+			pending = append(pending, perm)
+		}
+	}
+	required := len(pending)
+
+	// Walk from the quota itself towards the root
+	for i := len(chain) - 1; i >= 0 && len(pending) > 0; i-- {
+		resourceID := chain[i]
+		result, err := qs.authorizer.ResolvePermissions(userInfo, resourceID, pending)
+		if err != nil {
+			return nil, err
+		}
+
+		undecided := pending[:0]
+		for _, perm := range pending {
+			switch {
+			case result.Denies(perm):
+				decision.DeniedBy[perm] = resourceID
+			case result.Grants(perm):
+				decision.GrantedVia[perm] = resourceID
+				if resourceID != quotaID {
+					decision.Inherited = true
+				}
+			default:
+				undecided = append(undecided, perm)
+			}
+		}
+		pending = undecided
+	}
+
+	decision.Allowed = len(decision.GrantedVia) == required
+	return decision, nil
+}
+
+// getQuotaChain returns the IDs on a quota's path, root first
+func (qs *QuotaService) getQuotaChain(quotaID string) ([]string, error) {
+	var path string
+	err := qs.db.QueryRow(`SELECT path FROM quotas WHERE id = $1 AND status != $2`, quotaID, models.QuotaStatusDeleted).Scan(&path)
+	if err == sql.ErrNoRows {
+		// Unknown quotas can only be granted on directly
+		return []string{quotaID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota path: %w", err)
+	}
+
+	return pathQuotaIDs(path), nil
+}
+
+// pathQuotaIDs splits a materialized path (/quota_a/quota_b) into quota IDs, root first
+func pathQuotaIDs(path string) []string {
+	var ids []string
+	for _, id := range strings.Split(path, "/") {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// escapeLikePattern escapes LIKE wildcards; quota IDs contain "_"
+func escapeLikePattern(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (qs *QuotaService) getQuotaForUpdateTx(tx *sql.Tx, quotaID string) (*models.Quota, error) {
+	query := `
+		SELECT id, name, description, type, total_mb, used_mb, allocated_mb, 
+		       parent_quota_id, level, path, owner_id, organization_id, team_id, 
+		       status, created_at, updated_at, deleted_at, usage_mode, subtree_used_mb, overcommit_ratio,
+		       soft_limit_mb, warning_thresholds, reserved_mb
+		FROM quotas 
+		WHERE id = $1 AND status != $2
+		FOR UPDATE
+	`
+
+	quota := &models.Quota{}
+	row := tx.QueryRow(query, quotaID, models.QuotaStatusDeleted)
+
+	err := row.Scan(&quota.ID, &quota.Name, &quota.Description, &quota.Type,
+		&quota.TotalMB, &quota.UsedMB, &quota.AllocatedMB, &quota.ParentQuotaID,
+		&quota.Level, &quota.Path, &quota.OwnerID, &quota.OrganizationID, &quota.TeamID,
+		&quota.Status, &quota.CreatedAt, &quota.UpdatedAt, &quota.DeletedAt,
+		&quota.UsageMode, &quota.SubtreeUsedMB, &quota.OvercommitRatio,
+		&quota.SoftLimitMB, pq.Array(&quota.WarningThresholds), &quota.ReservedMB)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("quota not found")
+		}
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	// Calculate available MB
+	quota.ComputeCapacity()
+
+	return quota, nil
+}
+
+// checkAllocationTx locks the parent quota and checks that the requested
+// sub-quota fits: the parent is active, has the capacity (including any
+// overcommit allowance) and may allocate to the requested type. It returns
+// the locked parent and the child's overcommit ratio.
+func (qs *QuotaService) checkAllocationTx(tx *sql.Tx, parentQuotaID string, request *models.QuotaAllocateRequest) (*models.Quota, float64, error) {
+	parentQuota, err := qs.getQuotaForUpdateTx(tx, parentQuotaID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get parent quota: %w", err)
+	}
+	if parentQuota.Status == models.QuotaStatusSuspended {
+		return nil, 0, fmt.Errorf("%w: %s", ErrQuotaSuspended, parentQuotaID)
+	}
+
+	if parentQuota.AllocatableMB < request.AllocateMB {
+		return nil, 0, fmt.Errorf("insufficient quota: available %d MB, requested %d MB",
+			parentQuota.AllocatableMB, request.AllocateMB)
+	}
+	overcommitRatio, err := validateOvercommitRatio(request.OvercommitRatio, parentQuota.UsageMode)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := qs.validateAllocationRules(parentQuota, request); err != nil {
+		return nil, 0, err
+	}
+
+	return parentQuota, overcommitRatio, nil
+}
+
+func (qs *QuotaService) validateAllocationRules(parentQuota *models.Quota, request *models.QuotaAllocateRequest) error {
+	// Organization quota can allocate to team quota
+	if parentQuota.Type == models.QuotaTypeOrganization && request.Type == models.QuotaTypeTeam {
+		return nil
+	}
+
+	// Organization quota can allocate to organization quota
+	if parentQuota.Type == models.QuotaTypeOrganization && request.Type == models.QuotaTypeOrganization {
+		return nil
+	}
+
+	// Team quota can only allocate to same team
+	if parentQuota.Type == models.QuotaTypeTeam && request.Type == models.QuotaTypeTeam {
+		if parentQuota.TeamID == nil || request.TargetID != *parentQuota.TeamID {
+			return fmt.Errorf("team quota can only allocate to the same team")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("invalid allocation: %s quota cannot allocate to %s quota",
+		parentQuota.Type, request.Type)
+}
+
+func (qs *QuotaService) determineTeamID(parentQuota *models.Quota, request *models.QuotaAllocateRequest) *string {
+	if request.Type == models.QuotaTypeTeam {
+		return &request.TargetID
+	}
+	return parentQuota.TeamID
+}
+
+// GetRuntimeUsage retrieves usage data grouped by runtime (resource_id)
+func (qs *QuotaService) GetRuntimeUsage(userInfo *auth.UserInfo, page, pageSize int) (*models.RuntimeUsageResponse, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	offset := (page - 1) * pageSize
+
+	// Get runtime usage data with quota information
+	query := `
+		WITH runtime_usage AS (
+			SELECT 
+				qu.resource_id,
+				SUM(CASE WHEN qu.operation = 'allocate' THEN qu.usage_mb ELSE -qu.usage_mb END) as total_usage_mb,
+				COUNT(DISTINCT qu.quota_id) as quota_count,
+				MAX(qu.created_at) as last_activity,
+				ARRAY_AGG(DISTINCT q.id) as quota_ids,
+				ARRAY_AGG(DISTINCT q.name) as quota_names
+			FROM quota_usage qu
+			JOIN quotas q ON qu.quota_id = q.id
+			WHERE q.organization_id = $1 
+				AND q.status = 'active'
+				AND qu.resource_id IS NOT NULL
+			GROUP BY qu.resource_id
+			HAVING SUM(CASE WHEN qu.operation = 'allocate' THEN qu.usage_mb ELSE -qu.usage_mb END) > 0
+		)
+		SELECT 
+			resource_id,
 			total_usage_mb,
 			quota_count,
 			last_activity,
@@ -803,6 +2092,17 @@ func (qs *QuotaService) GetRuntimeUsage(userInfo *auth.UserInfo, page, pageSize
 	}, nil
 }
 
+// createAuditLog writes an audit log entry outside of any other transaction.
+// Failures are logged rather than returned.
+func (qs *QuotaService) createAuditLog(quotaID, actionType, actorUserID string, targetUserID *string, details map[string]interface{}) {
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		return qs.createAuditLogTx(tx, quotaID, actionType, actorUserID, targetUserID, details)
+	})
+	if err != nil {
+		qs.logger.WithError(err).Warn("Failed to create audit log")
+	}
+}
+
 func (qs *QuotaService) createAuditLogTx(tx *sql.Tx, quotaID, actionType, actorUserID string, targetUserID *string, details map[string]interface{}) error {
 	auditID := fmt.Sprintf("audit_%s", strings.ToLower(uuid.New().String()[:13]))
 	
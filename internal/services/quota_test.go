@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/database"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+func testQuota(id string, level int, parentID string) *models.Quota {
+	quota := &models.Quota{ID: id, Level: level}
+	if parentID != "" {
+		quota.ParentQuotaID = &parentID
+	}
+	return quota
+}
+
+func TestDeepestFirst(t *testing.T) {
+	tests := []struct {
+		name        string
+		root        *models.Quota
+		descendants []*models.Quota
+		want        []string
+	}{
+		{
+			name: "leaf quota",
+			root: testQuota("root", 1, ""),
+			want: []string{"root"},
+		},
+		{
+			name: "chain",
+			root: testQuota("a", 2, "p"),
+			descendants: []*models.Quota{
+				testQuota("b", 3, "a"),
+				testQuota("c", 4, "b"),
+			},
+			want: []string{"c", "b", "a"},
+		},
+		{
+			name: "branches of uneven depth",
+			root: testQuota("root", 1, ""),
+			descendants: []*models.Quota{
+				testQuota("a", 2, "root"),
+				testQuota("b", 2, "root"),
+				testQuota("a1", 3, "a"),
+				testQuota("b1", 3, "b"),
+				testQuota("b1x", 4, "b1"),
+			},
+			want: []string{"b1x", "b1", "a1", "b", "a", "root"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deepestFirst(tt.root, tt.descendants)
+			if len(got) != len(tt.want) {
+				t.Fatalf("deepestFirst() returned %d quotas; want %d", len(got), len(tt.want))
+			}
+
+			position := make(map[string]int, len(got))
+			for i, quota := range got {
+				if quota.ID != tt.want[i] {
+					t.Errorf("deepestFirst()[%d] = %s; want %s", i, quota.ID, tt.want[i])
+				}
+				position[quota.ID] = i
+			}
+
+			// Releasing in this order must never touch a parent before its children
+			for _, quota := range got {
+				if quota.ParentQuotaID == nil {
+					continue
+				}
+				if parent, ok := position[*quota.ParentQuotaID]; ok && parent < position[quota.ID] {
+					t.Errorf("%s is processed before its child %s", *quota.ParentQuotaID, quota.ID)
+				}
+			}
+		})
+	}
+}
+
+// permissionStub serves the two queries checkPermission makes against the
+// local permission engine: a quota's path, and the entries held on a quota
+type permissionStub struct {
+	paths   map[string]string
+	entries map[string][][2]string // quota ID -> (permission, effect)
+}
+
+func (s *permissionStub) Open(string) (driver.Conn, error) { return s, nil }
+func (s *permissionStub) Connect(context.Context) (driver.Conn, error) {
+	return s, nil
+}
+func (s *permissionStub) Driver() driver.Driver { return s }
+
+func (s *permissionStub) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (s *permissionStub) Close() error              { return nil }
+func (s *permissionStub) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (s *permissionStub) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.Contains(query, "SELECT path FROM quotas"):
+		path, ok := s.paths[args[0].Value.(string)]
+		if !ok {
+			return &stubRows{columns: []string{"path"}}, nil
+		}
+		return &stubRows{columns: []string{"path"}, values: [][]driver.Value{{path}}}, nil
+	case strings.Contains(query, "FROM quota_permissions"):
+		rows := &stubRows{columns: []string{"permission", "effect"}}
+		for _, entry := range s.entries[args[1].Value.(string)] {
+			rows.values = append(rows.values, []driver.Value{entry[0], entry[1]})
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+type stubRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestCheckPermissionDenyCoversImpliedPermissions(t *testing.T) {
+	stub := &permissionStub{
+		paths: map[string]string{
+			"root":  "/root",
+			"child": "/root/child",
+			"other": "/root/other",
+		},
+		entries: map[string][][2]string{
+			"root":  {{auth.QuotaPermissionAdmin, auth.PermissionEffectAllow}},
+			"child": {{auth.QuotaPermissionRead, auth.PermissionEffectDeny}},
+		},
+	}
+	sqlDB := sql.OpenDB(stub)
+	defer sqlDB.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	qs := NewQuotaService(&database.DB{DB: sqlDB}, auth.NewLocalAuthorizer(sqlDB, logger), logger)
+	userInfo := &auth.UserInfo{UserID: "user_1"}
+
+	tests := []struct {
+		quotaID     string
+		permission  string
+		wantAllowed bool
+		wantDecider string
+	}{
+		{quotaID: "child", permission: auth.QuotaPermissionRead, wantDecider: "child"},
+		{quotaID: "child", permission: auth.QuotaPermissionAdmin, wantDecider: "child"},
+		{quotaID: "other", permission: auth.QuotaPermissionRead, wantAllowed: true, wantDecider: "root"},
+		{quotaID: "other", permission: auth.QuotaPermissionAdmin, wantAllowed: true, wantDecider: "root"},
+		{quotaID: "root", permission: auth.QuotaPermissionRead, wantAllowed: true, wantDecider: "root"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.quotaID+"/"+tt.permission, func(t *testing.T) {
+			decision, err := qs.checkPermission(userInfo, tt.quotaID, []string{tt.permission})
+			if err != nil {
+				t.Fatalf("checkPermission() error = %v", err)
+			}
+			if decision.Allowed != tt.wantAllowed {
+				t.Fatalf("checkPermission() allowed = %v; want %v (granted via %v, denied by %v)",
+					decision.Allowed, tt.wantAllowed, decision.GrantedVia, decision.DeniedBy)
+			}
+			decider := decision.DeniedBy[tt.permission]
+			if tt.wantAllowed {
+				decider = decision.GrantedVia[tt.permission]
+			}
+			if decider != tt.wantDecider {
+				t.Fatalf("%s decided by %q; want %q", tt.permission, decider, tt.wantDecider)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/sirupsen/logrus"
+)
+
+// compensationAttempts bounds how often an undo call to the permission engine
+// is retried before it is logged as needing manual cleanup
+const compensationAttempts = 3
+
+// registerQuotaResource registers a new quota with the permission engine,
+// making the user its owner, and grants admin on it to adminUserIDs. It is
+// called before the quota row is written and without any row locks held, so a
+// slow auth service never stalls other allocations. If a grant fails, the
+// grants already made and the resource are removed again. Callers must
+// unregister the quota if the write then fails.
+func (qs *QuotaService) registerQuotaResource(userInfo *auth.UserInfo, quotaID, name, description string, adminUserIDs []string) error {
+	if err := qs.authorizer.CreateResource(userInfo, quotaID, "quota", name, description); err != nil {
+		return fmt.Errorf("failed to create quota resource: %w", err)
+	}
+
+	for i, adminUserID := range adminUserIDs {
+		err := qs.authorizer.GrantPermission(userInfo, adminUserID, quotaID, []string{auth.QuotaPermissionAdmin}, nil)
+		if err != nil {
+			qs.logger.WithError(err).WithFields(logrus.Fields{
+				"quota_id":      quotaID,
+				"admin_user_id": adminUserID,
+			}).Error("Failed to grant admin permission, rolling back registration")
+			qs.unregisterQuotaResource(userInfo, quotaID, adminUserIDs[:i])
+			return fmt.Errorf("failed to grant admin permission to %s: %w", adminUserID, err)
+		}
+	}
+
+	return nil
+}
+
+// unregisterQuotaResource undoes registerQuotaResource: admin grants are
+// revoked, then the resource is deleted. Failures are retried and, if they
+// persist, logged with enough context to clean up by hand.
+func (qs *QuotaService) unregisterQuotaResource(userInfo *auth.UserInfo, quotaID string, adminUserIDs []string) {
+	for _, adminUserID := range adminUserIDs {
+		fields := logrus.Fields{"quota_id": quotaID, "admin_user_id": adminUserID}
+		qs.compensate(fields, "revoke admin permission", func() error {
+			return qs.authorizer.RevokePermission(userInfo, adminUserID, quotaID, []string{auth.QuotaPermissionAdmin})
+		})
+	}
+
+	qs.compensate(logrus.Fields{"quota_id": quotaID}, "delete quota resource", func() error {
+		return qs.authorizer.DeleteResource(userInfo, quotaID)
+	})
+}
+
+// compensate runs an undo call against the permission engine, retrying with a
+// short backoff
+func (qs *QuotaService) compensate(fields logrus.Fields, action string, undo func() error) {
+	var err error
+	for attempt := 1; attempt <= compensationAttempts; attempt++ {
+		if err = undo(); err == nil {
+			return
+		}
+		qs.logger.WithError(err).WithFields(fields).WithField("attempt", attempt).Warn("Failed to " + action + ", retrying")
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+	qs.logger.WithError(err).WithFields(fields).Error("Failed to " + action + "; permission engine needs manual cleanup")
+}
@@ -0,0 +1,475 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// reaperActorID is the audit actor for reservations released by the reaper
+const reaperActorID = "system:reservation-reaper"
+
+// reaperBatchSize bounds how many expired reservations one reaper pass handles
+const reaperBatchSize = 100
+
+// SetReservationTTL sets the default and maximum lifetime of reservations
+func (qs *QuotaService) SetReservationTTL(defaultTTL, maxTTL time.Duration) error {
+	if defaultTTL <= 0 || maxTTL < defaultTTL {
+		return fmt.Errorf("invalid reservation TTL: default %s, max %s", defaultTTL, maxTTL)
+	}
+	qs.reservationDefaultTTL = defaultTTL
+	qs.reservationMaxTTL = maxTTL
+	return nil
+}
+
+// CreateReservation holds usage capacity on a quota until it is committed,
+// cancelled or expires. The held MB count towards used_mb (and rollups) so
+// other callers cannot take them in the meantime.
+func (qs *QuotaService) CreateReservation(userInfo *auth.UserInfo, quotaID string, request *models.QuotaReservationRequest) (*models.QuotaReservation, error) {
+	ttl := qs.reservationDefaultTTL
+	if request.TTLSeconds != 0 {
+		ttl = time.Duration(request.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > qs.reservationMaxTTL {
+		return nil, fmt.Errorf("invalid ttl_seconds: must be between 1 and %d", int(qs.reservationMaxTTL.Seconds()))
+	}
+
+	// Check read permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "reserve quota"); err != nil {
+		return nil, err
+	}
+
+	reservation := &models.QuotaReservation{
+		ID:         fmt.Sprintf("rsv_%s", strings.ToLower(uuid.New().String()[:13])),
+		QuotaID:    quotaID,
+		UserID:     userInfo.UserID,
+		ResourceID: request.ResourceID,
+		UsageMB:    request.UsageMB,
+		Status:     models.ReservationStatusActive,
+		Reason:     request.Reason,
+	}
+
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Get quota with lock (and its ancestors in rollup mode)
+		quota, err := qs.lockQuotaForUsageTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		if quota.Status == models.QuotaStatusSuspended {
+			return fmt.Errorf("%w: %s", ErrQuotaSuspended, quotaID)
+		}
+
+		// 2. Check available capacity, as for a usage allocation
+		if quota.AllocatableMB < request.UsageMB {
+			return fmt.Errorf("insufficient quota: available %d MB, requested %d MB",
+				quota.AllocatableMB, request.UsageMB)
+		}
+		if err := qs.checkPhysicalCapacityTx(tx, quota, request.UsageMB); err != nil {
+			return err
+		}
+
+		// 3. Hold the capacity. Held MB count as consumption, so crossing a
+		// warning limit is reported now rather than on commit.
+		beforeMB := quota.UsedMB + quota.AllocatedMB
+		if err := qs.applyUsageDeltaTx(tx, quota, request.UsageMB); err != nil {
+			return err
+		}
+		if err := qs.applyReservedDeltaTx(tx, quotaID, request.UsageMB); err != nil {
+			return err
+		}
+		reservation.Warnings = usageWarnings(quota, beforeMB, quota.UsedMB+quota.AllocatedMB)
+
+		// 4. Record the reservation
+		insertQuery := `
+			INSERT INTO quota_reservations (id, quota_id, user_id, resource_id, usage_mb, status, reason, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW() + $8 * INTERVAL '1 second', NOW())
+			RETURNING expires_at, created_at
+		`
+		err = tx.QueryRow(insertQuery, reservation.ID, quotaID, userInfo.UserID, request.ResourceID,
+			request.UsageMB, reservation.Status, request.Reason, int64(ttl.Seconds())).
+			Scan(&reservation.ExpiresAt, &reservation.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create reservation: %w", err)
+		}
+
+		// 5. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "reservation_create", userInfo.UserID, nil, map[string]interface{}{
+			"reservation_id": reservation.ID,
+			"resource_id":    request.ResourceID,
+			"usage_mb":       request.UsageMB,
+			"expires_at":     reservation.ExpiresAt,
+			"reason":         request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		// 6. Record crossed warning limits
+		for _, warning := range reservation.Warnings {
+			err = qs.createAuditLogTx(tx, quotaID, "threshold_crossed", userInfo.UserID, nil, map[string]interface{}{
+				"type":              warning.Type,
+				"threshold_percent": warning.ThresholdPercent,
+				"limit_mb":          warning.LimitMB,
+				"before_mb":         warning.BeforeMB,
+				"after_mb":          warning.AfterMB,
+				"resource_id":       request.ResourceID,
+				"reservation_id":    reservation.ID,
+			})
+			if err != nil {
+				qs.logger.WithError(err).Warn("Failed to create audit log")
+			}
+		}
+
+		return nil
+	})
+
+	var capacityErr *physicalCapacityError
+	if errors.As(err, &capacityErr) {
+		qs.reportOvercommitExhausted(userInfo, quotaID, capacityErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"reservation_id": reservation.ID,
+		"quota_id":       quotaID,
+		"resource_id":    request.ResourceID,
+		"usage_mb":       request.UsageMB,
+		"expires_at":     reservation.ExpiresAt,
+	}).Info("Reservation created successfully")
+
+	return reservation, nil
+}
+
+// CommitReservation turns an active reservation into real usage. The held MB
+// stay in used_mb and an allocate row is written to quota_usage.
+func (qs *QuotaService) CommitReservation(userInfo *auth.UserInfo, quotaID, reservationID string, request *models.QuotaReservationActionRequest) (*models.QuotaReservation, error) {
+	// Check read permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "commit quota reservation"); err != nil {
+		return nil, err
+	}
+
+	var reservation *models.QuotaReservation
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock the quota, then the reservation
+		if _, err := qs.lockQuotaForUsageTx(tx, quotaID); err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		var err error
+		reservation, err = qs.getActiveReservationForUpdateTx(tx, quotaID, reservationID, "commit")
+		if err != nil {
+			return err
+		}
+		// An expired hold may not have been reaped yet, but cannot be committed
+		if !reservation.ExpiresAt.After(time.Now()) {
+			return fmt.Errorf("cannot commit reservation: it expired at %s", reservation.ExpiresAt.Format(time.RFC3339))
+		}
+
+		// 2. Record usage
+		usageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
+		usageQuery := `
+			INSERT INTO quota_usage (id, quota_id, user_id, resource_id, usage_mb, operation, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		`
+		_, err = tx.Exec(usageQuery, usageID, quotaID, userInfo.UserID, reservation.ResourceID,
+			reservation.UsageMB, models.OperationAllocate, reservation.Reason)
+		if err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+
+		// 3. The held MB are now regular usage of the resource
+		if err := qs.applyReservedDeltaTx(tx, quotaID, -reservation.UsageMB); err != nil {
+			return err
+		}
+		if err := qs.applyResourceUsageDeltaTx(tx, quotaID, reservation.ResourceID, reservation.UsageMB); err != nil {
+			return err
+		}
+		if err := qs.resolveReservationTx(tx, reservation, models.ReservationStatusCommitted, &usageID); err != nil {
+			return err
+		}
+
+		// 4. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "reservation_commit", userInfo.UserID, nil, map[string]interface{}{
+			"reservation_id": reservation.ID,
+			"resource_id":    reservation.ResourceID,
+			"usage_mb":       reservation.UsageMB,
+			"usage_id":       usageID,
+			"reason":         request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"reservation_id": reservationID,
+		"quota_id":       quotaID,
+		"usage_mb":       reservation.UsageMB,
+	}).Info("Reservation committed successfully")
+
+	return reservation, nil
+}
+
+// CancelReservation releases an active reservation's held capacity
+func (qs *QuotaService) CancelReservation(userInfo *auth.UserInfo, quotaID, reservationID string, request *models.QuotaReservationActionRequest) (*models.QuotaReservation, error) {
+	// Check read permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "cancel quota reservation"); err != nil {
+		return nil, err
+	}
+
+	var reservation *models.QuotaReservation
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock the quota, then the reservation
+		quota, err := qs.lockQuotaForUsageTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		reservation, err = qs.getActiveReservationForUpdateTx(tx, quotaID, reservationID, "cancel")
+		if err != nil {
+			return err
+		}
+
+		// 2. Release the held capacity
+		if err := qs.releaseReservationTx(tx, quota, reservation, models.ReservationStatusCancelled); err != nil {
+			return err
+		}
+
+		// 3. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "reservation_cancel", userInfo.UserID, nil, map[string]interface{}{
+			"reservation_id": reservation.ID,
+			"resource_id":    reservation.ResourceID,
+			"usage_mb":       reservation.UsageMB,
+			"reason":         request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"reservation_id": reservationID,
+		"quota_id":       quotaID,
+		"usage_mb":       reservation.UsageMB,
+	}).Info("Reservation cancelled successfully")
+
+	return reservation, nil
+}
+
+// RunReservationReaper releases expired reservations, and purges idempotency
+// keys past their retention window, every interval until ctx is cancelled
+func (qs *QuotaService) RunReservationReaper(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		qs.logger.Warn("Reservation reaper disabled; expired reservations keep their capacity and idempotency keys are not purged")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	qs.logger.WithField("interval", interval).Info("Reservation reaper started")
+	for {
+		select {
+		case <-ctx.Done():
+			qs.logger.Info("Reservation reaper stopped")
+			return
+		case <-ticker.C:
+			expired, err := qs.ExpireReservations()
+			if err != nil {
+				qs.logger.WithError(err).Error("Failed to expire reservations")
+			} else if expired > 0 {
+				qs.logger.WithField("expired_count", expired).Info("Expired reservations released")
+			}
+
+			purged, err := qs.PurgeIdempotencyKeys()
+			if err != nil {
+				qs.logger.WithError(err).Error("Failed to purge idempotency keys")
+			} else if purged > 0 {
+				qs.logger.WithField("purged_count", purged).Info("Expired idempotency keys purged")
+			}
+		}
+	}
+}
+
+// ExpireReservations releases a batch of active reservations past their
+// expiry, each in its own transaction, and returns how many were released
+func (qs *QuotaService) ExpireReservations() (int, error) {
+	query := `
+		SELECT id, quota_id FROM quota_reservations
+		WHERE status = $1 AND expires_at <= NOW()
+		ORDER BY expires_at
+		LIMIT $2
+	`
+
+	rows, err := qs.db.Query(query, models.ReservationStatusActive, reaperBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired reservations: %w", err)
+	}
+	type candidate struct{ id, quotaID string }
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.quotaID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan expired reservations: %w", err)
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to get expired reservations: %w", err)
+	}
+
+	expired := 0
+	for _, c := range candidates {
+		released, err := qs.expireReservation(c.quotaID, c.id)
+		if err != nil {
+			qs.logger.WithError(err).WithFields(logrus.Fields{
+				"reservation_id": c.id,
+				"quota_id":       c.quotaID,
+			}).Warn("Failed to expire reservation")
+			continue
+		}
+		if released {
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// expireReservation releases one expired reservation. It reports false when
+// the reservation was committed or cancelled concurrently.
+func (qs *QuotaService) expireReservation(quotaID, reservationID string) (bool, error) {
+	released := false
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock the quota, then the reservation
+		quota, err := qs.lockQuotaForUsageTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		reservation, err := qs.getReservationForUpdateTx(tx, quotaID, reservationID)
+		if err != nil {
+			return err
+		}
+		if reservation.Status != models.ReservationStatusActive || reservation.ExpiresAt.After(time.Now()) {
+			return nil
+		}
+
+		// 2. Release the held capacity
+		if err := qs.releaseReservationTx(tx, quota, reservation, models.ReservationStatusExpired); err != nil {
+			return err
+		}
+		released = true
+
+		// 3. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "reservation_expire", reaperActorID, nil, map[string]interface{}{
+			"reservation_id": reservation.ID,
+			"resource_id":    reservation.ResourceID,
+			"usage_mb":       reservation.UsageMB,
+			"user_id":        reservation.UserID,
+			"expires_at":     reservation.ExpiresAt,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		return nil
+	})
+	return released, err
+}
+
+// getReservationForUpdateTx locks and returns a reservation on a quota
+func (qs *QuotaService) getReservationForUpdateTx(tx *sql.Tx, quotaID, reservationID string) (*models.QuotaReservation, error) {
+	query := `
+		SELECT id, quota_id, user_id, resource_id, usage_mb, status, COALESCE(reason, ''),
+		       usage_id, expires_at, created_at, resolved_at
+		FROM quota_reservations
+		WHERE id = $1 AND quota_id = $2
+		FOR UPDATE
+	`
+
+	reservation := &models.QuotaReservation{}
+	err := tx.QueryRow(query, reservationID, quotaID).Scan(&reservation.ID, &reservation.QuotaID,
+		&reservation.UserID, &reservation.ResourceID, &reservation.UsageMB, &reservation.Status,
+		&reservation.Reason, &reservation.UsageID, &reservation.ExpiresAt, &reservation.CreatedAt,
+		&reservation.ResolvedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("reservation not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reservation: %w", err)
+	}
+	return reservation, nil
+}
+
+// getActiveReservationForUpdateTx locks a reservation that can still be
+// committed or cancelled
+func (qs *QuotaService) getActiveReservationForUpdateTx(tx *sql.Tx, quotaID, reservationID, action string) (*models.QuotaReservation, error) {
+	reservation, err := qs.getReservationForUpdateTx(tx, quotaID, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	if reservation.Status != models.ReservationStatusActive {
+		return nil, fmt.Errorf("cannot %s reservation: it is %s", action, reservation.Status)
+	}
+	return reservation, nil
+}
+
+// releaseReservationTx gives a reservation's held MB back to the quota and
+// marks it with a final status. The caller must hold the locks taken by
+// lockQuotaForUsageTx.
+func (qs *QuotaService) releaseReservationTx(tx *sql.Tx, quota *models.Quota, reservation *models.QuotaReservation, status string) error {
+	// reserved_mb goes first, as it may never exceed used_mb
+	if err := qs.applyReservedDeltaTx(tx, quota.ID, -reservation.UsageMB); err != nil {
+		return err
+	}
+	if err := qs.applyUsageDeltaTx(tx, quota, -reservation.UsageMB); err != nil {
+		return err
+	}
+	return qs.resolveReservationTx(tx, reservation, status, nil)
+}
+
+// resolveReservationTx sets a reservation's final status
+func (qs *QuotaService) resolveReservationTx(tx *sql.Tx, reservation *models.QuotaReservation, status string, usageID *string) error {
+	updateQuery := `
+		UPDATE quota_reservations SET status = $1, usage_id = $2, resolved_at = NOW()
+		WHERE id = $3
+		RETURNING resolved_at
+	`
+	var resolvedAt time.Time
+	if err := tx.QueryRow(updateQuery, status, usageID, reservation.ID).Scan(&resolvedAt); err != nil {
+		return fmt.Errorf("failed to update reservation: %w", err)
+	}
+	reservation.Status = status
+	reservation.UsageID = usageID
+	reservation.ResolvedAt = &resolvedAt
+	return nil
+}
+
+// applyReservedDeltaTx changes the part of a quota's used_mb held by reservations
+func (qs *QuotaService) applyReservedDeltaTx(tx *sql.Tx, quotaID string, deltaMB int64) error {
+	updateQuery := `UPDATE quotas SET reserved_mb = reserved_mb + $1, updated_at = NOW() WHERE id = $2`
+	if _, err := tx.Exec(updateQuery, deltaMB, quotaID); err != nil {
+		return fmt.Errorf("failed to update reserved capacity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// TransferUsage moves usage from a resource on one quota to a resource on
+// another (or the same) quota in a single transaction, so the capacity cannot
+// be taken by anyone else in between. The source must hold the MB; the target
+// is checked like an allocation.
+func (qs *QuotaService) TransferUsage(userInfo *auth.UserInfo, request *models.QuotaUsageTransferRequest) (*models.QuotaUsageTransferResponse, error) {
+	sourceID, targetID := request.SourceQuotaID, request.TargetQuotaID
+	sameQuota := sourceID == targetID
+	if sameQuota && request.SourceResourceID == request.TargetResourceID {
+		return nil, fmt.Errorf("invalid transfer: source and target are the same resource")
+	}
+
+	// Check read permission on both quotas
+	if _, err := qs.authorize(userInfo, sourceID, []string{auth.QuotaPermissionRead}, "transfer quota usage"); err != nil {
+		return nil, err
+	}
+	if !sameQuota {
+		if _, err := qs.authorize(userInfo, targetID, []string{auth.QuotaPermissionRead}, "use quota"); err != nil {
+			return nil, err
+		}
+	}
+
+	response := &models.QuotaUsageTransferResponse{
+		TransferID:       fmt.Sprintf("xfer_%s", strings.ToLower(uuid.New().String()[:13])),
+		SourceResourceID: request.SourceResourceID,
+		TargetResourceID: request.TargetResourceID,
+		UsageMB:          request.UsageMB,
+		Source:           models.QuotaUsageResponse{QuotaID: sourceID, Warnings: []models.QuotaUsageWarning{}},
+		Target:           models.QuotaUsageResponse{QuotaID: targetID, Warnings: []models.QuotaUsageWarning{}},
+	}
+
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock both quotas (and their ancestors in rollup mode) in a
+		// deterministic order
+		if err := qs.lockUsageBatchTx(tx, []string{sourceID, targetID}); err != nil {
+			return err
+		}
+		source, err := qs.lockQuotaForUsageTx(tx, sourceID)
+		if err != nil {
+			return fmt.Errorf("failed to get source quota: %w", err)
+		}
+		target := source
+		if !sameQuota {
+			target, err = qs.lockQuotaForUsageTx(tx, targetID)
+			if err != nil {
+				return fmt.Errorf("failed to get target quota: %w", err)
+			}
+		}
+		if target.Status == models.QuotaStatusSuspended {
+			return fmt.Errorf("%w: %s", ErrQuotaSuspended, targetID)
+		}
+
+		// 2. The source resource must hold the MB
+		if inUseMB := source.UsedMB - source.ReservedMB; inUseMB < request.UsageMB {
+			return fmt.Errorf("cannot transfer %d MB, only %d MB in use on quota %s", request.UsageMB, inUseMB, sourceID)
+		}
+		if err := qs.checkResourceDeallocationTx(tx, sourceID, request.SourceResourceID, request.UsageMB); err != nil {
+			return err
+		}
+
+		// 3. A different target quota must have room, checked as for an
+		// allocation. Within one quota the transfer is net zero.
+		if !sameQuota && target.AllocatableMB < request.UsageMB {
+			return fmt.Errorf("insufficient quota: available %d MB, requested %d MB",
+				target.AllocatableMB, request.UsageMB)
+		}
+
+		// 4. Move the usage: source first, so shared rollup ancestors are
+		// checked with the MB already released
+		if err := qs.applyUsageDeltaTx(tx, source, -request.UsageMB); err != nil {
+			return err
+		}
+		if err := qs.applyResourceUsageDeltaTx(tx, sourceID, request.SourceResourceID, -request.UsageMB); err != nil {
+			return err
+		}
+		response.Source.UsedMB = source.UsedMB
+		response.Source.AvailableMB = source.AvailableMB
+
+		if !sameQuota {
+			if err := qs.checkPhysicalCapacityTx(tx, target, request.UsageMB); err != nil {
+				return err
+			}
+		}
+		beforeMB := target.UsedMB + target.AllocatedMB
+		if err := qs.applyUsageDeltaTx(tx, target, request.UsageMB); err != nil {
+			return err
+		}
+		if err := qs.applyResourceUsageDeltaTx(tx, targetID, request.TargetResourceID, request.UsageMB); err != nil {
+			return err
+		}
+		response.Target.UsedMB = target.UsedMB
+		response.Target.AvailableMB = target.AvailableMB
+		if !sameQuota {
+			response.Target.Warnings = usageWarnings(target, beforeMB, target.UsedMB+target.AllocatedMB)
+			response.Target.Warning = len(response.Target.Warnings) > 0
+		} else {
+			response.Source.UsedMB = target.UsedMB
+			response.Source.AvailableMB = target.AvailableMB
+		}
+
+		// 5. Record the linked pair of usage rows
+		sourceUsageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
+		targetUsageID := fmt.Sprintf("usage_%s", strings.ToLower(uuid.New().String()[:13]))
+		usageQuery := `
+			INSERT INTO quota_usage (id, quota_id, user_id, resource_id, usage_mb, operation, reason, transfer_id, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		`
+		_, err = tx.Exec(usageQuery, sourceUsageID, sourceID, userInfo.UserID, request.SourceResourceID,
+			request.UsageMB, models.OperationDeallocate, request.Reason, response.TransferID)
+		if err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+		_, err = tx.Exec(usageQuery, targetUsageID, targetID, userInfo.UserID, request.TargetResourceID,
+			request.UsageMB, models.OperationAllocate, request.Reason, response.TransferID)
+		if err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+
+		// 6. Create audit log
+		err = qs.createAuditLogTx(tx, sourceID, "usage_transfer", userInfo.UserID, nil, map[string]interface{}{
+			"transfer_id":        response.TransferID,
+			"source_quota_id":    sourceID,
+			"source_resource_id": request.SourceResourceID,
+			"source_usage_id":    sourceUsageID,
+			"target_quota_id":    targetID,
+			"target_resource_id": request.TargetResourceID,
+			"target_usage_id":    targetUsageID,
+			"usage_mb":           request.UsageMB,
+			"reason":             request.Reason,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		// 7. Record crossed warning limits on the target
+		for _, warning := range response.Target.Warnings {
+			err = qs.createAuditLogTx(tx, targetID, "threshold_crossed", userInfo.UserID, nil, map[string]interface{}{
+				"type":              warning.Type,
+				"threshold_percent": warning.ThresholdPercent,
+				"limit_mb":          warning.LimitMB,
+				"before_mb":         warning.BeforeMB,
+				"after_mb":          warning.AfterMB,
+				"resource_id":       request.TargetResourceID,
+				"transfer_id":       response.TransferID,
+			})
+			if err != nil {
+				qs.logger.WithError(err).Warn("Failed to create audit log")
+			}
+		}
+
+		return nil
+	})
+
+	var capacityErr *physicalCapacityError
+	if errors.As(err, &capacityErr) {
+		qs.reportOvercommitExhausted(userInfo, targetID, capacityErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	qs.logger.WithFields(logrus.Fields{
+		"transfer_id":        response.TransferID,
+		"source_quota_id":    sourceID,
+		"source_resource_id": request.SourceResourceID,
+		"target_quota_id":    targetID,
+		"target_resource_id": request.TargetResourceID,
+		"usage_mb":           request.UsageMB,
+	}).Info("Usage transferred successfully")
+
+	return response, nil
+}
@@ -0,0 +1,271 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/lib/pq"
+)
+
+// GetQuotaTree returns a quota and its descendants as a nested tree. Each node
+// carries the used_mb of its whole subtree. depth limits how many levels below
+// the quota are returned (0 means unlimited); rollups always cover the full
+// subtree. In enforce mode, nodes the caller cannot read are omitted along
+// with their children.
+func (qs *QuotaService) GetQuotaTree(userInfo *auth.UserInfo, quotaID string, depth int) (*models.QuotaTreeNode, error) {
+	// Check read permission
+	decision, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "view quota tree")
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := qs.getQuotaChain(quotaID)
+	if err != nil {
+		return nil, err
+	}
+	rootPath := "/"
+	for i, id := range chain {
+		if i > 0 {
+			rootPath += "/"
+		}
+		rootPath += id
+	}
+
+	// 1. Load the live subtree, shallowest first
+	query := `
+		SELECT id, name, description, type, total_mb, used_mb, allocated_mb,
+		       parent_quota_id, level, path, owner_id, organization_id, team_id,
+		       status, created_at, updated_at, deleted_at, usage_mode, subtree_used_mb, overcommit_ratio,
+		       soft_limit_mb, warning_thresholds, reserved_mb
+		FROM quotas
+		WHERE (id = $1 OR path LIKE $2 ESCAPE '\') AND status != $3
+		ORDER BY level, id
+	`
+
+	rows, err := qs.db.Query(query, quotaID, escapeLikePattern(rootPath)+"/%", models.QuotaStatusDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota tree: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make(map[string]*models.QuotaTreeNode)
+	var ordered []*models.QuotaTreeNode
+	for rows.Next() {
+		node := &models.QuotaTreeNode{Children: []*models.QuotaTreeNode{}}
+		quota := &node.Quota
+		err := rows.Scan(&quota.ID, &quota.Name, &quota.Description, &quota.Type,
+			&quota.TotalMB, &quota.UsedMB, &quota.AllocatedMB, &quota.ParentQuotaID,
+			&quota.Level, &quota.Path, &quota.OwnerID, &quota.OrganizationID, &quota.TeamID,
+			&quota.Status, &quota.CreatedAt, &quota.UpdatedAt, &quota.DeletedAt,
+			&quota.UsageMode, &quota.SubtreeUsedMB, &quota.OvercommitRatio,
+			&quota.SoftLimitMB, pq.Array(&quota.WarningThresholds), &quota.ReservedMB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan quota tree: %w", err)
+		}
+		quota.ComputeCapacity()
+		// Recomputed below, whatever the tree's usage mode
+		quota.SubtreeUsedMB = quota.UsedMB
+		nodes[quota.ID] = node
+		ordered = append(ordered, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get quota tree: %w", err)
+	}
+
+	root, exists := nodes[quotaID]
+	if !exists {
+		return nil, fmt.Errorf("quota not found")
+	}
+	root.Access = decision
+
+	// 2. Roll usage up, deepest nodes first, before anything is filtered out
+	for i := len(ordered) - 1; i >= 0; i-- {
+		node := ordered[i]
+		if node.ID == quotaID || node.ParentQuotaID == nil {
+			continue
+		}
+		if parent, exists := nodes[*node.ParentQuotaID]; exists {
+			parent.SubtreeUsedMB += node.SubtreeUsedMB
+		}
+	}
+	for _, node := range ordered {
+		node.UtilizationPercent = utilizationPercent(node.SubtreeUsedMB, node.TotalMB)
+	}
+
+	// 3. Link visible nodes within the requested depth. Parents come before
+	// children, so a node's parent has already been decided.
+	readable := map[string]bool{quotaID: true}
+	for _, node := range ordered {
+		if node.ID == quotaID || node.ParentQuotaID == nil {
+			continue
+		}
+		parent, exists := nodes[*node.ParentQuotaID]
+		if !exists || !readable[parent.ID] {
+			continue
+		}
+
+		// Nodes below the depth limit are not checked or returned
+		if depth > 0 && node.Level-root.Level > depth {
+			parent.Truncated = true
+			continue
+		}
+
+		canRead, err := qs.canReadTreeNode(userInfo, node.ID, readable[parent.ID])
+		if err != nil {
+			return nil, err
+		}
+		if !canRead {
+			continue
+		}
+		readable[node.ID] = true
+		parent.Children = append(parent.Children, node)
+	}
+
+	return root, nil
+}
+
+// GetQuotaAncestors returns the chain from the root down to a quota, with the
+// minimum headroom along it (see ancestorHeadroom). Ancestors the caller
+// cannot read are returned with their capacity redacted, but still count
+// towards the effective figure.
+func (qs *QuotaService) GetQuotaAncestors(userInfo *auth.UserInfo, quotaID string) (*models.QuotaAncestorsResponse, error) {
+	// Check read permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionRead}, "view quota ancestors"); err != nil {
+		return nil, err
+	}
+
+	chain, err := qs.getQuotaChain(quotaID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, name, type, level, status, total_mb, used_mb, allocated_mb,
+		       usage_mode, subtree_used_mb
+		FROM quotas
+		WHERE id = ANY($1) AND status != $2
+	`
+
+	rows, err := qs.db.Query(query, pq.Array(chain), models.QuotaStatusDeleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota ancestors: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make(map[string]*models.QuotaAncestor)
+	for rows.Next() {
+		entry := &models.QuotaAncestor{}
+		err := rows.Scan(&entry.QuotaID, &entry.Name, &entry.Type, &entry.Level, &entry.Status,
+			&entry.TotalMB, &entry.UsedMB, &entry.AllocatedMB,
+			&entry.UsageMode, &entry.SubtreeUsedMB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan quota ancestors: %w", err)
+		}
+		entry.AvailableMB = entry.TotalMB - entry.UsedMB - entry.AllocatedMB
+		entries[entry.QuotaID] = entry
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get quota ancestors: %w", err)
+	}
+
+	if _, exists := entries[quotaID]; !exists {
+		return nil, fmt.Errorf("quota not found")
+	}
+
+	ordered := make([]*models.QuotaAncestor, 0, len(chain))
+	for _, id := range chain {
+		entry, exists := entries[id]
+		if !exists {
+			return nil, fmt.Errorf("quota ancestor %s not found", id)
+		}
+		ordered = append(ordered, entry)
+	}
+
+	response := &models.QuotaAncestorsResponse{
+		QuotaID:   quotaID,
+		Ancestors: make([]models.QuotaAncestor, 0, len(chain)),
+	}
+	response.EffectiveAvailableMB, response.BottleneckQuotaID = ancestorHeadroom(ordered)
+
+	readable := false
+	for _, entry := range ordered {
+		id := entry.QuotaID
+		if id == quotaID {
+			readable = true
+		} else if readable, err = qs.canReadTreeNode(userInfo, id, readable); err != nil {
+			return nil, err
+		}
+
+		if !readable {
+			entry = &models.QuotaAncestor{
+				QuotaID:    entry.QuotaID,
+				Level:      entry.Level,
+				Restricted: true,
+			}
+		}
+		response.Ancestors = append(response.Ancestors, *entry)
+	}
+
+	return response, nil
+}
+
+// ancestorHeadroom sets HeadroomMB on a root-first chain ending at the queried
+// quota and returns the minimum along it, with the quota that sets it (the
+// nearest one wins ties). The queried quota's headroom is its own
+// available_mb. An ancestor's allocated_mb includes what it handed to the next
+// quota down the path, which that branch can still use, so the allocation is
+// added back; in rollup mode an ancestor is bounded physically instead, by
+// total_mb - subtree_used_mb.
+func ancestorHeadroom(chain []*models.QuotaAncestor) (int64, string) {
+	var effectiveMB int64
+	var bottleneckID string
+	for i, entry := range chain {
+		switch {
+		case i == len(chain)-1:
+			entry.HeadroomMB = entry.AvailableMB
+		case entry.UsageMode == models.UsageModeRollup:
+			entry.HeadroomMB = entry.TotalMB - entry.SubtreeUsedMB
+		default:
+			entry.HeadroomMB = entry.AvailableMB + chain[i+1].TotalMB
+		}
+
+		if i == 0 || entry.HeadroomMB <= effectiveMB {
+			effectiveMB = entry.HeadroomMB
+			bottleneckID = entry.QuotaID
+		}
+	}
+	return effectiveMB, bottleneckID
+}
+
+// canReadTreeNode decides read access on a tree node from its own explicit
+// grant or deny, falling back to what it inherits from its parent. Outside
+// enforce mode every node is readable.
+func (qs *QuotaService) canReadTreeNode(userInfo *auth.UserInfo, quotaID string, inherited bool) (bool, error) {
+	if qs.authzMode != AuthzModeEnforce {
+		return true, nil
+	}
+
+	result, err := qs.authorizer.ResolvePermissions(userInfo, quotaID, []string{auth.QuotaPermissionRead})
+	if err != nil {
+		return false, fmt.Errorf("failed to check permissions: %w", err)
+	}
+
+	switch {
+	case result.Denies(auth.QuotaPermissionRead):
+		return false, nil
+	case result.Grants(auth.QuotaPermissionRead):
+		return true, nil
+	default:
+		return inherited, nil
+	}
+}
+
+// utilizationPercent returns used/total as a percentage rounded to two decimals
+func utilizationPercent(usedMB, totalMB int64) float64 {
+	if totalMB <= 0 {
+		return 0
+	}
+	return math.Round(float64(usedMB)/float64(totalMB)*10000) / 100
+}
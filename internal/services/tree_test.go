@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/emagen-ai/cagen-quota/internal/models"
+)
+
+func TestAncestorHeadroom(t *testing.T) {
+	tests := []struct {
+		name           string
+		chain          []*models.QuotaAncestor
+		wantMB         int64
+		wantBottleneck string
+		wantHeadroom   []int64
+	}{
+		{
+			name: "parent fully allocated to the queried child",
+			chain: []*models.QuotaAncestor{
+				{QuotaID: "parent", TotalMB: 100, AllocatedMB: 100, AvailableMB: 0, UsageMode: models.UsageModeDirect},
+				{QuotaID: "child", TotalMB: 100, UsedMB: 10, AvailableMB: 90, UsageMode: models.UsageModeDirect},
+			},
+			wantMB:         90,
+			wantBottleneck: "child",
+			wantHeadroom:   []int64{100, 90},
+		},
+		{
+			name: "sibling allocations limit the parent",
+			chain: []*models.QuotaAncestor{
+				{QuotaID: "root", TotalMB: 1000, AllocatedMB: 500, AvailableMB: 500, UsageMode: models.UsageModeDirect},
+				{QuotaID: "parent", TotalMB: 500, UsedMB: 20, AllocatedMB: 450, AvailableMB: 30, UsageMode: models.UsageModeDirect},
+				{QuotaID: "child", TotalMB: 50, UsedMB: 0, AvailableMB: 50, UsageMode: models.UsageModeDirect},
+			},
+			wantMB:         50,
+			wantBottleneck: "child",
+			wantHeadroom:   []int64{1000, 80, 50},
+		},
+		{
+			name: "parent's own usage does not eat into allocated room",
+			chain: []*models.QuotaAncestor{
+				{QuotaID: "parent", TotalMB: 100, UsedMB: 70, AllocatedMB: 30, AvailableMB: 0, UsageMode: models.UsageModeDirect},
+				{QuotaID: "child", TotalMB: 30, AvailableMB: 30, UsageMode: models.UsageModeDirect},
+			},
+			wantMB:         30,
+			wantBottleneck: "child",
+			wantHeadroom:   []int64{30, 30},
+		},
+		{
+			name: "overcommitted rollup ancestor is bounded physically",
+			chain: []*models.QuotaAncestor{
+				{QuotaID: "root", TotalMB: 100, AllocatedMB: 150, AvailableMB: -50, SubtreeUsedMB: 95, UsageMode: models.UsageModeRollup},
+				{QuotaID: "child", TotalMB: 75, UsedMB: 40, AvailableMB: 35, SubtreeUsedMB: 40, UsageMode: models.UsageModeRollup},
+			},
+			wantMB:         5,
+			wantBottleneck: "root",
+			wantHeadroom:   []int64{5, 35},
+		},
+		{
+			name: "root quota alone",
+			chain: []*models.QuotaAncestor{
+				{QuotaID: "root", TotalMB: 100, UsedMB: 25, AllocatedMB: 25, AvailableMB: 50, UsageMode: models.UsageModeDirect},
+			},
+			wantMB:         50,
+			wantBottleneck: "root",
+			wantHeadroom:   []int64{50},
+		},
+		{
+			name: "nearest quota wins ties",
+			chain: []*models.QuotaAncestor{
+				{QuotaID: "root", TotalMB: 100, UsedMB: 50, AllocatedMB: 50, AvailableMB: 0, UsageMode: models.UsageModeDirect},
+				{QuotaID: "child", TotalMB: 50, AvailableMB: 50, UsageMode: models.UsageModeDirect},
+			},
+			wantMB:         50,
+			wantBottleneck: "child",
+			wantHeadroom:   []int64{50, 50},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotMB, gotBottleneck := ancestorHeadroom(tt.chain)
+			if gotMB != tt.wantMB || gotBottleneck != tt.wantBottleneck {
+				t.Errorf("ancestorHeadroom() = %d, %q; want %d, %q", gotMB, gotBottleneck, tt.wantMB, tt.wantBottleneck)
+			}
+			for i, entry := range tt.chain {
+				if entry.HeadroomMB != tt.wantHeadroom[i] {
+					t.Errorf("%s headroom = %d; want %d", entry.QuotaID, entry.HeadroomMB, tt.wantHeadroom[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/emagen-ai/cagen-quota/internal/auth"
+	"github.com/emagen-ai/cagen-quota/internal/models"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// lockQuotaForUsageTx locks a quota before changing its usage. In rollup mode
+// its ancestors are locked first, root first, so usage changes take row locks
+// in the same parent-before-child order as allocation and resize.
+func (qs *QuotaService) lockQuotaForUsageTx(tx *sql.Tx, quotaID string) (*models.Quota, error) {
+	var usageMode, path string
+	err := tx.QueryRow(`SELECT usage_mode, path FROM quotas WHERE id = $1 AND status != $2`,
+		quotaID, models.QuotaStatusDeleted).Scan(&usageMode, &path)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("quota not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	if usageMode == models.UsageModeRollup {
+		ids := pathQuotaIDs(path)
+		if err := qs.lockQuotasTx(tx, ids[:len(ids)-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	quota, err := qs.getQuotaForUpdateTx(tx, quotaID)
+	if err != nil {
+		return nil, err
+	}
+	if quota.Path != path {
+		return nil, fmt.Errorf("quota hierarchy changed during update, please retry")
+	}
+
+	return quota, nil
+}
+
+// lockQuotasTx locks the given live quotas, shallowest first
+func (qs *QuotaService) lockQuotasTx(tx *sql.Tx, quotaIDs []string) error {
+	if len(quotaIDs) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`SELECT id FROM quotas WHERE id = ANY($1) AND status != $2 ORDER BY level, id FOR UPDATE`,
+		pq.Array(quotaIDs), models.QuotaStatusDeleted)
+	if err != nil {
+		return fmt.Errorf("failed to lock quotas: %w", err)
+	}
+	return nil
+}
+
+// lockQuotasWithSubtreeTx locks the given live quotas together with the live
+// descendants of the quota at path, shallowest first across the whole set. Use
+// it when some of the given quotas sit deeper than the subtree, where locking
+// them first and the subtree afterwards would break the level order.
+func (qs *QuotaService) lockQuotasWithSubtreeTx(tx *sql.Tx, quotaIDs []string, path string) error {
+	_, err := tx.Exec(`
+		SELECT id FROM quotas
+		WHERE (id = ANY($1) OR path LIKE $2 ESCAPE '\') AND status != $3
+		ORDER BY level, id
+		FOR UPDATE
+	`, pq.Array(quotaIDs), escapeLikePattern(path)+"/%", models.QuotaStatusDeleted)
+	if err != nil {
+		return fmt.Errorf("failed to lock quotas: %w", err)
+	}
+	return nil
+}
+
+// applyUsageDeltaTx changes a quota's used_mb by deltaMB. In rollup mode the
+// same delta is applied to subtree_used_mb on the quota and every ancestor.
+// The caller must hold the locks taken by lockQuotaForUsageTx.
+func (qs *QuotaService) applyUsageDeltaTx(tx *sql.Tx, quota *models.Quota, deltaMB int64) error {
+	updateQuery := `UPDATE quotas SET used_mb = used_mb + $1, updated_at = NOW() WHERE id = $2`
+	_, err := tx.Exec(updateQuery, deltaMB, quota.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update quota usage: %w", err)
+	}
+
+	if quota.UsageMode == models.UsageModeRollup {
+		if err := qs.applyRollupDeltaTx(tx, pathQuotaIDs(quota.Path), deltaMB); err != nil {
+			return err
+		}
+	}
+
+	quota.UsedMB += deltaMB
+	quota.AvailableMB -= deltaMB
+	return nil
+}
+
+// applyRollupDeltaTx adds deltaMB to subtree_used_mb on the given quotas
+func (qs *QuotaService) applyRollupDeltaTx(tx *sql.Tx, quotaIDs []string, deltaMB int64) error {
+	if len(quotaIDs) == 0 || deltaMB == 0 {
+		return nil
+	}
+
+	rollupQuery := `UPDATE quotas SET subtree_used_mb = subtree_used_mb + $1 WHERE id = ANY($2)`
+	_, err := tx.Exec(rollupQuery, deltaMB, pq.Array(quotaIDs))
+	if err != nil {
+		return fmt.Errorf("failed to update usage rollups: %w", err)
+	}
+	return nil
+}
+
+// setSubtreeUsageModeTx switches a quota and its descendants to another usage
+// mode. Entering rollup mode recomputes subtree_used_mb; leaving it clears it.
+func (qs *QuotaService) setSubtreeUsageModeTx(tx *sql.Tx, quotaID, path, usageMode string) error {
+	modeQuery := `
+		UPDATE quotas SET usage_mode = $1, subtree_used_mb = 0, updated_at = NOW()
+		WHERE id = $2 OR path LIKE $3 ESCAPE '\'
+	`
+	_, err := tx.Exec(modeQuery, usageMode, quotaID, escapeLikePattern(path)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to update usage mode: %w", err)
+	}
+
+	dimensionsQuery := `
+		UPDATE quota_dimensions SET subtree_used = 0
+		WHERE quota_id IN (SELECT id FROM quotas WHERE id = $1 OR path LIKE $2 ESCAPE '\')
+	`
+	_, err = tx.Exec(dimensionsQuery, quotaID, escapeLikePattern(path)+"/%")
+	if err != nil {
+		return fmt.Errorf("failed to update dimension usage mode: %w", err)
+	}
+
+	if usageMode != models.UsageModeRollup {
+		return nil
+	}
+
+	if err := qs.recomputeDimensionRollupsTx(tx, quotaID, escapeLikePattern(path)+"/%"); err != nil {
+		return err
+	}
+
+	recomputeQuery := `
+		UPDATE quotas q SET subtree_used_mb = c.computed_mb
+		FROM (` + computedRollupsQuery + `) c
+		WHERE q.id = c.id
+	`
+	_, err = tx.Exec(recomputeQuery, quotaID, escapeLikePattern(path)+"/%", models.QuotaStatusDeleted)
+	if err != nil {
+		return fmt.Errorf("failed to recompute usage rollups: %w", err)
+	}
+	return nil
+}
+
+// computedRollupsQuery recomputes subtree_used_mb from scratch for a quota and
+// its descendants. $1 is the quota ID, $2 the escaped descendant LIKE pattern
+// and $3 the deleted status.
+const computedRollupsQuery = `
+	SELECT q.id, q.subtree_used_mb,
+	       COALESCE((
+	           SELECT SUM(d.used_mb) FROM quotas d
+	           WHERE d.status != $3
+	             AND (d.id = q.id OR d.path LIKE replace(replace(replace(q.path, '\', '\\'), '%', '\%'), '_', '\_') || '/%' ESCAPE '\')
+	       ), 0) AS computed_mb
+	FROM quotas q
+	WHERE (q.id = $1 OR q.path LIKE $2 ESCAPE '\') AND q.status != $3
+	ORDER BY q.level, q.id
+`
+
+// CheckRollups recomputes subtree_used_mb for a rollup-mode quota and its
+// descendants and reports every counter that drifted. With repair the stored
+// counters are overwritten with the recomputed values.
+func (qs *QuotaService) CheckRollups(userInfo *auth.UserInfo, quotaID string, repair bool) (*models.QuotaRollupCheckResponse, error) {
+	// Check admin permission
+	if _, err := qs.authorize(userInfo, quotaID, []string{auth.QuotaPermissionAdmin}, "check quota rollups"); err != nil {
+		return nil, err
+	}
+
+	response := &models.QuotaRollupCheckResponse{
+		QuotaID: quotaID,
+		Drift:   []models.QuotaRollupDrift{},
+	}
+
+	err := qs.db.WithTransaction(func(tx *sql.Tx) error {
+		// 1. Lock the quota, then its subtree parent before child, so usage
+		// cannot change mid-check. Rollup-mode usage writes lock their
+		// ancestors root first, so the two cannot deadlock.
+		quota, err := qs.getQuotaForUpdateTx(tx, quotaID)
+		if err != nil {
+			return fmt.Errorf("failed to get quota: %w", err)
+		}
+		if quota.UsageMode != models.UsageModeRollup {
+			return fmt.Errorf("quota %s does not use rollup accounting", quotaID)
+		}
+		subtree, err := qs.getSubtreeForUpdateTx(tx, quota)
+		if err != nil {
+			return err
+		}
+		response.CheckedCount = len(subtree)
+
+		// 2. Recompute rollups from used_mb
+		rows, err := tx.Query(computedRollupsQuery, quotaID, escapeLikePattern(quota.Path)+"/%", models.QuotaStatusDeleted)
+		if err != nil {
+			return fmt.Errorf("failed to recompute rollups: %w", err)
+		}
+		for rows.Next() {
+			var drift models.QuotaRollupDrift
+			if err := rows.Scan(&drift.QuotaID, &drift.StoredMB, &drift.ComputedMB); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan rollups: %w", err)
+			}
+			if drift.StoredMB != drift.ComputedMB {
+				response.Drift = append(response.Drift, drift)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to recompute rollups: %w", err)
+		}
+
+		if !repair || len(response.Drift) == 0 {
+			return nil
+		}
+
+		// 3. Overwrite drifted counters
+		for _, drift := range response.Drift {
+			_, err := tx.Exec(`UPDATE quotas SET subtree_used_mb = $1, updated_at = NOW() WHERE id = $2`,
+				drift.ComputedMB, drift.QuotaID)
+			if err != nil {
+				return fmt.Errorf("failed to repair rollup for quota %s: %w", drift.QuotaID, err)
+			}
+		}
+		response.Repaired = true
+
+		// 4. Create audit log
+		err = qs.createAuditLogTx(tx, quotaID, "rollup_repair", userInfo.UserID, nil, map[string]interface{}{
+			"checked_count": response.CheckedCount,
+			"drift":         response.Drift,
+		})
+		if err != nil {
+			qs.logger.WithError(err).Warn("Failed to create audit log")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Drift) > 0 {
+		qs.logger.WithFields(logrus.Fields{
+			"quota_id":    quotaID,
+			"drift_count": len(response.Drift),
+			"repaired":    response.Repaired,
+		}).Warn("Quota rollup drift detected")
+	}
+
+	return response, nil
+}
+
+// validateOvercommitRatio defaults an unset ratio to 1. Ratios above 1 need
+// rollup accounting, since real consumption is checked against subtree_used_mb.
+func validateOvercommitRatio(ratio float64, usageMode string) (float64, error) {
+	if ratio == 0 {
+		return 1, nil
+	}
+	if ratio < 1 || ratio > maxOvercommitRatio {
+		return 0, fmt.Errorf("overcommit_ratio must be between 1 and %d", maxOvercommitRatio)
+	}
+	if ratio > 1 && usageMode != models.UsageModeRollup {
+		return 0, fmt.Errorf("overcommit requires rollup usage mode")
+	}
+	return ratio, nil
+}
+
+// validateMoveOvercommit rejects moving a subtree holding overcommitted quotas
+// under a parent that does not use rollup accounting: the subtree adopts the
+// parent's mode, and overcommit is only checked physically in rollup mode.
+func validateMoveOvercommit(subtree []*models.Quota, newParentUsageMode string) error {
+	if newParentUsageMode == models.UsageModeRollup {
+		return nil
+	}
+	for _, q := range subtree {
+		if q.OvercommitRatio > 1 {
+			return fmt.Errorf("cannot move: overcommitted subtree requires rollup parent (quota %s has overcommit_ratio %g)",
+				q.ID, q.OvercommitRatio)
+		}
+	}
+	return nil
+}
+
+// maxOvercommitRatio is the largest ratio the overcommit_ratio column can hold
+const maxOvercommitRatio = 999
+
+// physicalCapacityError reports an overcommitted quota whose real consumption
+// would exceed its total_mb
+type physicalCapacityError struct {
+	QuotaID     string
+	TotalMB     int64
+	SubtreeMB   int64
+	RequestedMB int64
+}
+
+func (e *physicalCapacityError) Error() string {
+	return fmt.Sprintf("insufficient quota: overcommitted quota %s has %d MB physically available, requested %d MB",
+		e.QuotaID, e.TotalMB-e.SubtreeMB, e.RequestedMB)
+}
+
+// checkPhysicalCapacityTx verifies that adding deltaMB of usage to a rollup
+// quota keeps every overcommitted quota on its path within its total_mb.
+// The caller must hold the locks taken by lockQuotaForUsageTx.
+func (qs *QuotaService) checkPhysicalCapacityTx(tx *sql.Tx, quota *models.Quota, deltaMB int64) error {
+	if quota.UsageMode != models.UsageModeRollup {
+		return nil
+	}
+	return qs.checkPhysicalCapacityOfTx(tx, pathQuotaIDs(quota.Path), deltaMB)
+}
+
+// checkPhysicalCapacityOfTx verifies that adding deltaMB to subtree_used_mb of
+// the given rollup quotas keeps the overcommitted ones within their total_mb.
+// The caller must hold locks on the quotas.
+func (qs *QuotaService) checkPhysicalCapacityOfTx(tx *sql.Tx, quotaIDs []string, deltaMB int64) error {
+	if len(quotaIDs) == 0 || deltaMB <= 0 {
+		return nil
+	}
+
+	query := `
+		SELECT id, total_mb, subtree_used_mb FROM quotas
+		WHERE id = ANY($1) AND overcommit_ratio > 1
+		ORDER BY level
+	`
+	rows, err := tx.Query(query, pq.Array(quotaIDs))
+	if err != nil {
+		return fmt.Errorf("failed to check physical capacity: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		capacityErr := &physicalCapacityError{RequestedMB: deltaMB}
+		if err := rows.Scan(&capacityErr.QuotaID, &capacityErr.TotalMB, &capacityErr.SubtreeMB); err != nil {
+			return fmt.Errorf("failed to scan physical capacity: %w", err)
+		}
+		if capacityErr.SubtreeMB+deltaMB > capacityErr.TotalMB {
+			return capacityErr
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check physical capacity: %w", err)
+	}
+	return nil
+}
+
+// reportOvercommitExhausted records an alert when usage was refused because an
+// overcommitted quota ran out of physical capacity
+func (qs *QuotaService) reportOvercommitExhausted(userInfo *auth.UserInfo, quotaID string, capacityErr *physicalCapacityError) {
+	qs.logger.WithFields(logrus.Fields{
+		"quota_id":            quotaID,
+		"overcommit_quota_id": capacityErr.QuotaID,
+		"total_mb":            capacityErr.TotalMB,
+		"subtree_used_mb":     capacityErr.SubtreeMB,
+		"requested_mb":        capacityErr.RequestedMB,
+	}).Warn("Overcommitted quota physical capacity exhausted")
+
+	qs.createAuditLog(capacityErr.QuotaID, "overcommit_exhausted", userInfo.UserID, nil, map[string]interface{}{
+		"requesting_quota_id": quotaID,
+		"total_mb":            capacityErr.TotalMB,
+		"subtree_used_mb":     capacityErr.SubtreeMB,
+		"requested_mb":        capacityErr.RequestedMB,
+	})
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emagen-ai/cagen-quota/internal/models"
+)
+
+func TestValidateOvercommitRatio(t *testing.T) {
+	tests := []struct {
+		name      string
+		ratio     float64
+		usageMode string
+		want      float64
+		wantErr   string
+	}{
+		{name: "unset defaults to 1", ratio: 0, usageMode: models.UsageModeDirect, want: 1},
+		{name: "1 in direct mode", ratio: 1, usageMode: models.UsageModeDirect, want: 1},
+		{name: "overcommit in rollup mode", ratio: 1.5, usageMode: models.UsageModeRollup, want: 1.5},
+		{name: "overcommit in direct mode", ratio: 1.5, usageMode: models.UsageModeDirect, wantErr: "requires rollup"},
+		{name: "below 1", ratio: 0.5, usageMode: models.UsageModeRollup, wantErr: "between 1 and"},
+		{name: "above column maximum", ratio: 1000, usageMode: models.UsageModeRollup, wantErr: "between 1 and"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateOvercommitRatio(tt.ratio, tt.usageMode)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateOvercommitRatio() error = %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("validateOvercommitRatio() = %g, %v; want %g", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateMoveOvercommit(t *testing.T) {
+	plain := &models.Quota{ID: "plain", OvercommitRatio: 1}
+	overcommitted := &models.Quota{ID: "overcommitted", OvercommitRatio: 2}
+
+	tests := []struct {
+		name       string
+		subtree    []*models.Quota
+		parentMode string
+		wantErr    bool
+	}{
+		{name: "plain subtree under direct parent", subtree: []*models.Quota{plain}, parentMode: models.UsageModeDirect},
+		{name: "overcommitted descendant under direct parent", subtree: []*models.Quota{overcommitted, plain}, parentMode: models.UsageModeDirect, wantErr: true},
+		{name: "overcommitted subtree under rollup parent", subtree: []*models.Quota{overcommitted, plain}, parentMode: models.UsageModeRollup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMoveOvercommit(tt.subtree, tt.parentMode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateMoveOvercommit() error = %v; want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
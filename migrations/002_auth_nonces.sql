@@ -0,0 +1,9 @@
+-- Replay protection for encrypted user payloads
+-- Used when NONCE_STORE=postgres so that all replicas share one nonce set
+
+CREATE TABLE IF NOT EXISTS auth_nonces (
+    nonce VARCHAR(255) PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_nonces_expires ON auth_nonces(expires_at);
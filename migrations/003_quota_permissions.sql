@@ -0,0 +1,13 @@
+-- Local permission grants
+-- Used when AUTHORIZER=local instead of the remote cagen-auth-service
+
+CREATE TABLE IF NOT EXISTS quota_permissions (
+    resource_id VARCHAR(50) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    permission VARCHAR(20) NOT NULL CHECK (permission IN ('read', 'admin', 'owner')),
+    granted_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (resource_id, user_id, permission)
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_permissions_user ON quota_permissions(user_id);
@@ -0,0 +1,5 @@
+-- Explicit deny entries for local permission grants
+-- A deny on a quota overrides grants inherited from its ancestors
+
+ALTER TABLE quota_permissions
+    ADD COLUMN IF NOT EXISTS effect VARCHAR(10) NOT NULL DEFAULT 'allow' CHECK (effect IN ('allow', 'deny'));
@@ -0,0 +1,4 @@
+-- Time-boxed local permission grants
+-- Expired rows are ignored by permission checks and listings
+
+ALTER TABLE quota_permissions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
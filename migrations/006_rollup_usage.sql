@@ -0,0 +1,14 @@
+-- Rollup usage accounting
+-- usage_mode is set on a root quota and inherited by its sub-quotas. In rollup
+-- mode subtree_used_mb holds the used_mb of a quota and all of its descendants.
+
+ALTER TABLE quotas ADD COLUMN IF NOT EXISTS usage_mode VARCHAR(20) NOT NULL DEFAULT 'direct' CHECK (usage_mode IN ('direct', 'rollup'));
+ALTER TABLE quotas ADD COLUMN IF NOT EXISTS subtree_used_mb BIGINT NOT NULL DEFAULT 0 CHECK (subtree_used_mb >= 0);
+
+-- Backfill rollups from current usage (ids contain "_", so escape LIKE wildcards)
+UPDATE quotas q SET subtree_used_mb = COALESCE((
+    SELECT SUM(d.used_mb) FROM quotas d
+    WHERE d.status != 'deleted'
+      AND (d.id = q.id OR d.path LIKE replace(replace(replace(q.path, '\', '\\'), '%', '\%'), '_', '\_') || '/%' ESCAPE '\')
+), 0)
+WHERE q.status != 'deleted';
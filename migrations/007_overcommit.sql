@@ -0,0 +1,14 @@
+-- Overcommit (borrowing) for sub-quotas
+-- A quota may allocate up to total_mb * overcommit_ratio to its children. Real
+-- consumption is then bounded by the rollup subtree_used_mb instead.
+
+ALTER TABLE quotas ADD COLUMN IF NOT EXISTS overcommit_ratio NUMERIC(6,3) NOT NULL DEFAULT 1 CHECK (overcommit_ratio >= 1);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'quota_overcommit_check' AND conrelid = 'quotas'::regclass) THEN
+        ALTER TABLE quotas DROP CONSTRAINT IF EXISTS quota_balance_check;
+        ALTER TABLE quotas ADD CONSTRAINT quota_overcommit_check CHECK (used_mb + allocated_mb <= total_mb * overcommit_ratio);
+    END IF;
+END
+$$;
@@ -0,0 +1,6 @@
+-- Soft limits and warning thresholds
+-- Crossing either does not reject usage; the usage call returns a warning and
+-- a threshold_crossed audit entry is written.
+
+ALTER TABLE quotas ADD COLUMN IF NOT EXISTS soft_limit_mb BIGINT CHECK (soft_limit_mb > 0);
+ALTER TABLE quotas ADD COLUMN IF NOT EXISTS warning_thresholds INTEGER[] NOT NULL DEFAULT '{}';
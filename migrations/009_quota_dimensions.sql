@@ -0,0 +1,38 @@
+-- Multi-dimensional resources
+-- Quotas can meter named resources besides storage MB, each with its own unit
+-- and the same total/used/allocated hierarchy accounting as the MB columns.
+
+CREATE TABLE IF NOT EXISTS quota_dimensions (
+    quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+    dimension VARCHAR(50) NOT NULL,
+    unit VARCHAR(20) NOT NULL,
+    total BIGINT NOT NULL CHECK (total >= 0),
+    used BIGINT NOT NULL DEFAULT 0 CHECK (used >= 0),
+    allocated BIGINT NOT NULL DEFAULT 0 CHECK (allocated >= 0),
+    subtree_used BIGINT NOT NULL DEFAULT 0 CHECK (subtree_used >= 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (quota_id, dimension),
+    CONSTRAINT quota_dimension_balance_check CHECK (used + allocated <= total)
+);
+
+CREATE TABLE IF NOT EXISTS quota_usage_dimensions (
+    usage_id VARCHAR(50) NOT NULL REFERENCES quota_usage(id),
+    dimension VARCHAR(50) NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    PRIMARY KEY (usage_id, dimension)
+);
+
+-- A usage row may now carry dimensions only
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname = 'quota_usage_usage_mb_check' AND conrelid = 'quota_usage'::regclass
+          AND pg_get_constraintdef(oid) LIKE '%usage_mb > 0%'
+    ) THEN
+        ALTER TABLE quota_usage DROP CONSTRAINT quota_usage_usage_mb_check;
+        ALTER TABLE quota_usage ADD CONSTRAINT quota_usage_usage_mb_check CHECK (usage_mb >= 0);
+    END IF;
+END
+$$;
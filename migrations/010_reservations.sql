@@ -0,0 +1,30 @@
+-- Usage reservations
+-- A reservation holds usage capacity on a quota until it is committed,
+-- cancelled or expires. Held MB count towards used_mb; reserved_mb tracks the
+-- part of used_mb that is held.
+
+ALTER TABLE quotas ADD COLUMN IF NOT EXISTS reserved_mb BIGINT NOT NULL DEFAULT 0 CHECK (reserved_mb >= 0);
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'quota_reserved_check' AND conrelid = 'quotas'::regclass) THEN
+        ALTER TABLE quotas ADD CONSTRAINT quota_reserved_check CHECK (reserved_mb <= used_mb);
+    END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS quota_reservations (
+    id VARCHAR(50) PRIMARY KEY,
+    quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+    user_id VARCHAR(255) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL,
+    usage_mb BIGINT NOT NULL CHECK (usage_mb > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'committed', 'cancelled', 'expired')),
+    reason TEXT,
+    usage_id VARCHAR(50) REFERENCES quota_usage(id),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_reservations_quota ON quota_reservations(quota_id);
+CREATE INDEX IF NOT EXISTS idx_quota_reservations_active_expiry ON quota_reservations(expires_at) WHERE status = 'active';
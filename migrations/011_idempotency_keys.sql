@@ -0,0 +1,16 @@
+-- Idempotency keys for usage allocate/deallocate
+-- A retried request with the same key returns the stored result instead of
+-- recording the usage again. Keys are unique per quota.
+
+CREATE TABLE IF NOT EXISTS quota_idempotency_keys (
+    quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+    idempotency_key VARCHAR(255) NOT NULL,
+    operation VARCHAR(20) NOT NULL CHECK (operation IN ('allocate', 'deallocate')),
+    request_hash VARCHAR(64) NOT NULL,
+    usage_id VARCHAR(50) NOT NULL REFERENCES quota_usage(id),
+    response JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (quota_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_idempotency_keys_created ON quota_idempotency_keys(created_at);
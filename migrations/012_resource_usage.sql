@@ -0,0 +1,23 @@
+-- Per-resource usage ledger
+-- Holds the current usage balance of each resource on a quota. Deallocations
+-- are checked against it, and PUT /quotas/:id/usage/:resource_id sets it.
+
+CREATE TABLE IF NOT EXISTS quota_resource_usage (
+    quota_id VARCHAR(50) NOT NULL REFERENCES quotas(id),
+    resource_id VARCHAR(255) NOT NULL,
+    used_mb BIGINT NOT NULL DEFAULT 0 CHECK (used_mb >= 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (quota_id, resource_id)
+);
+
+-- Backfill balances from the usage history of live quotas
+INSERT INTO quota_resource_usage (quota_id, resource_id, used_mb)
+SELECT u.quota_id, u.resource_id,
+       SUM(CASE WHEN u.operation = 'allocate' THEN u.usage_mb ELSE -u.usage_mb END)
+FROM quota_usage u
+JOIN quotas q ON q.id = u.quota_id AND q.status != 'deleted'
+WHERE u.resource_id IS NOT NULL
+GROUP BY u.quota_id, u.resource_id
+HAVING SUM(CASE WHEN u.operation = 'allocate' THEN u.usage_mb ELSE -u.usage_mb END) > 0
+ON CONFLICT (quota_id, resource_id) DO NOTHING;
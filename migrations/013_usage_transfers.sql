@@ -0,0 +1,7 @@
+-- Usage transfers
+-- A transfer moves usage between (quota, resource) pairs in one transaction.
+-- It writes a deallocate and an allocate row that share a transfer_id.
+
+ALTER TABLE quota_usage ADD COLUMN IF NOT EXISTS transfer_id VARCHAR(50);
+
+CREATE INDEX IF NOT EXISTS idx_quota_usage_transfer ON quota_usage(transfer_id) WHERE transfer_id IS NOT NULL;